@@ -30,6 +30,7 @@ import (
 
 // Cli initiates a command line interface, executing requested command.
 func Cli(command string, strict bool, instance string, sibling string, owner string, reason string, pattern string) {
+	go handleShutdownSignals()
 
 	if instance != "" && !strings.Contains(instance, ":") {
 		instance = fmt.Sprintf("%s:%d", instance, config.Config.DefaultInstancePort)
@@ -119,6 +120,19 @@ func Cli(command string, strict bool, instance string, sibling string, owner str
 				log.Errore(err)
 			}
 		}
+	case "match-below-online":
+		{
+			if instanceKey == nil {
+				log.Fatal("Cannot deduce instance:", instance)
+			}
+			if siblingKey == nil {
+				log.Fatal("Cannot deduce sibling:", sibling)
+			}
+			_, _, err := inst.MatchBelowOnline(instanceKey, siblingKey, true, true)
+			if err != nil {
+				log.Errore(err)
+			}
+		}
 	case "get-candidate-slave":
 		{
 			if instanceKey == nil {
@@ -36,6 +36,7 @@ import (
 // Http starts serving
 func Http(discovery bool) {
 	martini.Env = martini.Prod
+	go handleShutdownSignals()
 	if config.Config.ServeAgentsHttp {
 		go agentsHttp()
 	}
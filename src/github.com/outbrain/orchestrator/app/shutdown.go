@@ -0,0 +1,52 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package app
+
+import (
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator/inst"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownScansGracePeriod is the time given to aborted binlog scans to wind down before exiting
+const shutdownScansGracePeriod = 5 * time.Second
+
+// handleShutdownSignals waits for a termination signal, upon which it aborts all in-flight binlog scans, so as not
+// to leave heavy queries running on topology servers. It then re-raises the signal with its default handling
+// restored, such that the process terminates as it would have without this handler. This applies to both
+// HTTP and CLI modes.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+
+	abortedCount := inst.AbortBinlogScans()
+	log.Infof("Received %+v; aborted %d binlog scans", sig, abortedCount)
+	for deadline := time.Now().Add(shutdownScansGracePeriod); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if inst.ActiveBinlogScansCount() == 0 {
+			break
+		}
+	}
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+	if err := syscall.Kill(os.Getpid(), sig.(syscall.Signal)); err != nil {
+		log.Errore(err)
+		os.Exit(1)
+	}
+}
@@ -24,99 +24,197 @@ import (
 	"github.com/outbrain/golib/log"
 )
 
+// MySQLCredentials is a user/password pair used to connect to a MySQL server
+type MySQLCredentials struct {
+	User     string
+	Password string
+}
+
+// BinlogEventsColumns names the columns of SHOW BINLOG EVENTS/SHOW RELAYLOG EVENTS output
+type BinlogEventsColumns struct {
+	LogName   string
+	Pos       string
+	EndLogPos string
+	EventType string
+	Info      string
+	ServerId  string // Optional; when empty, events' ServerId is not read
+}
+
 // Configuration makes for orchestrator configuration input, which can be provided by user via JSON formatted file.
 // Some of the parameteres have reasonable default values, and some (like database credentials) are
 // strictly expected from user.
 type Configuration struct {
-	ListenAddress                              string
-	MySQLTopologyUser                          string
-	MySQLTopologyPassword                      string // my.cnf style configuration file from where to pick credentials. Expecting `user`, `password` under `[client]` section
-	MySQLTopologyCredentialsConfigFile         string
-	MySQLTopologyMaxPoolConnections            int // Max concurrent connections on any topology instance
-	MySQLOrchestratorHost                      string
-	MySQLOrchestratorPort                      uint
-	MySQLOrchestratorDatabase                  string
-	MySQLOrchestratorUser                      string
-	MySQLOrchestratorPassword                  string
-	MySQLOrchestratorCredentialsConfigFile     string // my.cnf style configuration file from where to pick credentials. Expecting `user`, `password` under `[client]` section
-	MySQLConnectTimeoutSeconds                 int    // Number of seconds before connection is aborted (driver-side)
-	DefaultInstancePort                        uint   // In case port was not specified on command line
-	SlaveLagQuery                              string // custom query to check on slave lg (e.g. heartbeat table)
-	SlaveStartPostWaitMilliseconds             int    // Time to wait after START SLAVE before re-readong instance (give slave chance to connect to master)
-	DiscoverByShowSlaveHosts                   bool   // Attempt SHOW SLAVE HOSTS before PROCESSLIST
-	InstancePollSeconds                        uint   // Number of seconds between instance reads
-	UnseenInstanceForgetHours                  uint   // Number of hours after which an unseen instance is forgotten
-	DiscoveryPollSeconds                       uint   // Auto/continuous discovery of instances sleep time between polls
-	InstanceBulkOperationsWaitTimeoutSeconds   uint   // Time to wait on a single instance when doing bulk (many instances) operation
-	ActiveNodeExpireSeconds                    uint
-	HostnameResolveMethod                      string // Method by which to "normalize" hostname ("none"/"default"/"cname")
-	ExpiryHostnameResolvesMinutes              int    // Number of minutes after which to expire hostname-resolves
-	RejectHostnameResolvePattern               string // Regexp pattern for resolved hostname that will not be accepted (not cached, not written to db). This is done to avoid storing wrong resovles due to network glitches.
-	ReasonableReplicationLagSeconds            int    // Abvoe this value is considered a problem
-	ReasonableMaintenanceReplicationLagSeconds int    // Above this value move-up and move-below are blocked
-	AuditLogFile                               string // Name of log file for audit operations. Disabled when empty.
-	AuditPageSize                              int
-	ReadOnly                                   bool
-	AuthenticationMethod                       string            // Type of autherntication to use, if any. "" for none, "basic" for BasicAuth, "multi" for advanced BasicAuth, "proxy" for forwarded credentials via reverse proxy
-	HTTPAuthUser                               string            // Username for HTTP Basic authentication (blank disables authentication)
-	HTTPAuthPassword                           string            // Password for HTTP Basic authentication
-	AuthUserHeader                             string            // HTTP header indicating auth user, when AuthenticationMethod is "proxy"
-	PowerAuthUsers                             []string          // On AuthenticationMethod == "proxy", list of users that can make changes. All others are read-only.
-	ClusterNameToAlias                         map[string]string // map between regex matching cluster name to a human friendly alias
-	ServeAgentsHttp                            bool              // Spawn another HTTP interface dedicated for orcehstrator-agent
-	AgentsUseSSL                               bool              // When "true" orchestrator will listen on agents port with SSL as well as connect to agents via SSL
-	SSLSkipVerify                              bool              // When using SSL, should we ignore SSL certification error
-	SSLPrivateKeyFile                          string            // Name of SSL private key file, applies only when AgentsUseSSL = true
-	SSLCertFile                                string            // Name of SSL certification file, applies only when AgentsUseSSL = true
-	HttpTimeoutSeconds                         int               // Number of idle seconds before HTTP GET request times out (when accessing orchestrator-agent)
-	AgentPollMinutes                           uint              // Minutes between agent polling
-	UnseenAgentForgetHours                     uint              // Number of hours after which an unseen agent is forgotten
-	StaleSeedFailMinutes                       uint              // Number of minutes after which a stale (no progress) seed is considered failed.
-	SeedAcceptableBytesDiff                    int64             // Difference in bytes between seed source & target data size that is still considered as successful copy
-	PseudoGTIDPattern                          string            // Pattern to look for in binary logs that makes for a unique entry (pseudo GTID). When empty, Pseudo-GTID based refactoring is disabled.
+	ListenAddress                               string
+	MySQLTopologyUser                           string
+	MySQLTopologyPassword                       string // my.cnf style configuration file from where to pick credentials. Expecting `user`, `password` under `[client]` section
+	MySQLTopologyCredentialsConfigFile          string
+	MySQLTopologyMaxPoolConnections             int // Max concurrent connections on any topology instance
+	MySQLOrchestratorHost                       string
+	MySQLOrchestratorPort                       uint
+	MySQLOrchestratorDatabase                   string
+	MySQLOrchestratorUser                       string
+	MySQLOrchestratorPassword                   string
+	MySQLOrchestratorCredentialsConfigFile      string              // my.cnf style configuration file from where to pick credentials. Expecting `user`, `password` under `[client]` section
+	MySQLConnectTimeoutSeconds                  int                 // Number of seconds before connection is aborted (driver-side)
+	BinlogConnectTimeoutSeconds                 int                 // Number of seconds before connection is aborted (driver-side) for binary/relay log scans. 0 means same as MySQLConnectTimeoutSeconds
+	BinlogReaderMethod                          string              // How binary logs are read: "sql" (SHOW BINLOG EVENTS) or "mysqlbinlog" (mysqlbinlog --read-from-remote-server). Relay logs are always read via SQL. Instances listed in BinlogFilesDirectories are read off disk either way
+	MysqlbinlogCommand                          string              // mysqlbinlog executable to run when BinlogReaderMethod is "mysqlbinlog"
+	BinlogEventsColumns                         BinlogEventsColumns // Column names of SHOW BINLOG EVENTS output, for servers (forks) where these differ from MySQL's. Validated upon startup
+	DefaultInstancePort                         uint                // In case port was not specified on command line
+	SlaveLagQuery                               string              // custom query to check on slave lg (e.g. heartbeat table)
+	SlaveStartPostWaitMilliseconds              int                 // Time to wait after START SLAVE before re-readong instance (give slave chance to connect to master)
+	DiscoverByShowSlaveHosts                    bool                // Attempt SHOW SLAVE HOSTS before PROCESSLIST
+	InstancePollSeconds                         uint                // Number of seconds between instance reads
+	UnseenInstanceForgetHours                   uint                // Number of hours after which an unseen instance is forgotten
+	DiscoveryPollSeconds                        uint                // Auto/continuous discovery of instances sleep time between polls
+	InstanceBulkOperationsWaitTimeoutSeconds    uint                // Time to wait on a single instance when doing bulk (many instances) operation
+	ActiveNodeExpireSeconds                     uint
+	HostnameResolveMethod                       string // Method by which to "normalize" hostname ("none"/"default"/"cname")
+	ExpiryHostnameResolvesMinutes               int    // Number of minutes after which to expire hostname-resolves
+	RejectHostnameResolvePattern                string // Regexp pattern for resolved hostname that will not be accepted (not cached, not written to db). This is done to avoid storing wrong resovles due to network glitches.
+	ReasonableReplicationLagSeconds             int    // Abvoe this value is considered a problem
+	ReasonableMaintenanceReplicationLagSeconds  int    // Above this value move-up and move-below are blocked
+	AuditLogFile                                string // Name of log file for audit operations. Disabled when empty.
+	AuditPageSize                               int
+	ReadOnly                                    bool
+	AuthenticationMethod                        string                      // Type of autherntication to use, if any. "" for none, "basic" for BasicAuth, "multi" for advanced BasicAuth, "proxy" for forwarded credentials via reverse proxy
+	HTTPAuthUser                                string                      // Username for HTTP Basic authentication (blank disables authentication)
+	HTTPAuthPassword                            string                      // Password for HTTP Basic authentication
+	AuthUserHeader                              string                      // HTTP header indicating auth user, when AuthenticationMethod is "proxy"
+	PowerAuthUsers                              []string                    // On AuthenticationMethod == "proxy", list of users that can make changes. All others are read-only.
+	ClusterNameToAlias                          map[string]string           // map between regex matching cluster name to a human friendly alias
+	ServeAgentsHttp                             bool                        // Spawn another HTTP interface dedicated for orcehstrator-agent
+	AgentsUseSSL                                bool                        // When "true" orchestrator will listen on agents port with SSL as well as connect to agents via SSL
+	SSLSkipVerify                               bool                        // When using SSL, should we ignore SSL certification error
+	SSLPrivateKeyFile                           string                      // Name of SSL private key file, applies only when AgentsUseSSL = true
+	SSLCertFile                                 string                      // Name of SSL certification file, applies only when AgentsUseSSL = true
+	HttpTimeoutSeconds                          int                         // Number of idle seconds before HTTP GET request times out (when accessing orchestrator-agent)
+	AgentPollMinutes                            uint                        // Minutes between agent polling
+	UnseenAgentForgetHours                      uint                        // Number of hours after which an unseen agent is forgotten
+	StaleSeedFailMinutes                        uint                        // Number of minutes after which a stale (no progress) seed is considered failed.
+	SeedAcceptableBytesDiff                     int64                       // Difference in bytes between seed source & target data size that is still considered as successful copy
+	PseudoGTIDInjectionStatement                string                      // Statement injecting a Pseudo-GTID entry on a master, with a %s placeholder for a unique marker, e.g. "drop view if exists meta._pseudo_gtid_hint__%s". Must match PseudoGTIDPattern. Used for end to end verification of Pseudo-GTID propagation; orchestrator does not inject on its own
+	PseudoGTIDPattern                           string                      // Pattern to look for in binary logs that makes for a unique entry (pseudo GTID). When empty, Pseudo-GTID based refactoring is disabled.
+	PseudoGTIDCompareCanonicalSQL               bool                        // When true, binlog event Info is compared by canonical SQL (normalized whitespace, lowercased outside quotes) rather than raw text. More expensive; useful on statement based replication
+	PseudoGTIDMatchInterchangeableCommitMarkers bool                        // When true, matching treats commit markers (Xid events, COMMIT Query events) as equal, regardless of representation, such that a match does not fail solely on servers differing in how they log transaction commit
+	PseudoGTIDMatchQueryEventsOnly              bool                        // When true, only Query events are tested against PseudoGTIDPattern, skipping row events (whose Info may hold binary data). Faster scans, no false matches on row payloads
+	PseudoGTIDTimestampPattern                  string                      // Regexp with a single capturing group, extracting the unix timestamp embedded in Pseudo-GTID entries (if any). Used for clock skew detection
+	PseudoGTIDTimestampHexadecimal              bool                        // When true, the timestamp extracted by PseudoGTIDTimestampPattern is hexadecimal
+	PseudoGTIDCacheVerifyMinutes                uint                        // Interval at which cached Pseudo-GTID entry coordinates are verified against live binary logs. 0 disables
+	VerifyPseudoGTIDCacheHits                   bool                        // When true, cached Pseudo-GTID entry coordinates are confirmed against the live binary log (a single event read) before being used
+	PseudoGTIDCacheWarmMinutes                  uint                        // Interval at which the latest Pseudo-GTID entry of each instance is looked up and cached ahead of need (e.g. failover). 0 disables
+	PseudoGTIDCacheBucketPattern                string                      // When non-empty, a regular expression whose first submatch identifies a bucket of Pseudo-GTID entries (e.g. a timestamp truncated to the minute). Entries matching it are cached by bucket rather than individually, reducing cache size for high-frequency injection; the cached earliest entry of a bucket is where searches for the bucket's entries begin
+	RelayLogScanSafetyMarginBytes               int64                       // When scanning relay logs for Pseudo-GTID entries, stop this many bytes before Relay_log_pos, so as to avoid half-written trailing events on fast replicating slaves
+	RelayLogScanStrictExecutedBound             bool                        // When true, a relay log Pseudo-GTID entry positioned exactly at Relay_log_pos (read by the IO thread but not yet applied by the SQL thread) is not used for matching. Recommended on semi-sync replicas
+	PseudoGTIDMatchIgnorePatterns               []string                    // Regexp patterns of binlog event Info (e.g. heartbeat table statements or "db.table" in Table_map events) which are ignored on both sides while matching, along with transactions consisting solely of such events
+	PseudoGTIDMatchMaxFallbackAnchors           uint                        // When an instance's latest Pseudo-GTID entry cannot be found on the instance it is matched below, retry with up to this many successively older entries. 0 disables
+	PseudoGTIDSearchMaxBinlogs                  uint                        // When > 0, give up searching for the latest Pseudo-GTID entry of an instance after this many newest binary logs without a match. 0 searches all binary logs
+	PseudoGTIDFreshBinlogsWaitSeconds           uint                        // When an instance has no binary logs, or a single nearly empty one (up to 1MB), with no Pseudo-GTID entry (e.g. just started, or RESET MASTER), wait up to this many seconds for an entry to show up. 0 does not wait
+	PseudoGTIDMatchMismatchLookahead            uint                        // Upon mismatching events while matching, look up to this many events ahead on either side for the two streams to re-sync before aborting. 0 aborts on first mismatch
+	BinlogMatchTrace                            bool                        // When true, each and every binlog event compared while matching is logged (at debug level). Very verbose; for troubleshooting only
+	BinlogEventsInfoTruncationLength            int                         // Info read via SHOW BINLOG EVENTS of this length or longer is considered truncated by the server, as is Info ending with "...". 0 relies on the ellipsis alone
+	BinlogEventsTruncatedInfoFallback           bool                        // When true, events whose Info seems truncated are re-read via mysqlbinlog (see MysqlbinlogCommand) to get their full statement. Binary logs only
+	BinlogEventsStrictPositions                 bool                        // When true, each chunk of binary log events read is verified to have each event's End_log_pos beyond its position, and End_log_pos increasing throughout. A violation (driver/version quirk, corruption) fails the read, rather than silently break coordinate arithmetic. For troubleshooting
+	BinlogMatchSelfCoordinatesTolerance         int64                       // When matching from an instance's binary logs, accept a scan ending up to this many bytes away from the instance's recorded master status (same binary log only). 0 requires exact match
+	SkipMatchSelfCoordinatesCheck               bool                        // When true, a binary log match not ending at the instance's master status (nor within BinlogMatchSelfCoordinatesTolerance) is logged rather than failed. Emergency use only
+	BinlogScanVerifyEndOfLog                    bool                        // When true, reaching the end of binary logs while matching is re-confirmed via SHOW MASTER STATUS, so that a transient empty result does not prematurely end the scan
+	BinlogScanCredentials                       map[string]MySQLCredentials // Per-instance ("host:port") credentials used when scanning binary/relay logs. Instances not listed use MySQLTopologyUser/MySQLTopologyPassword
+	BinlogFilesDirectories                      map[string]string           // Per-instance ("host:port") local directory where that instance's binary logs are mirrored. Scans of such instances read the files directly rather than via SHOW BINLOG EVENTS
+	BinlogServers                               map[string]string           // Maps a master ("host:port") onto a binlog server ("host:port") holding that master's binary logs under the same file names. Reads of the master's binary logs are then served by the binlog server
+	RelayLogFilesDirectories                    map[string]string           // Per-instance ("host:port") local directory where that instance's relay logs are available. Used when the server does not support SHOW RELAYLOG EVENTS
+	BinlogScanAllowedHostnames                  []string                    // When non-empty, only these hosts may have their binary/relay logs scanned
+	BinlogScanDeniedHostnames                   []string                    // Hosts which may never have their binary/relay logs scanned (e.g. sensitive, heavily loaded servers). Takes precedence over BinlogScanAllowedHostnames
+	BinlogScanCircuitBreakerFailures            uint                        // After this many consecutive failed binlog scans of a host, further scans of that host fail immediately for BinlogScanCircuitBreakerCooldownSeconds. 0 disables the circuit breaker
+	BinlogScanCircuitBreakerCooldownSeconds     uint                        // Time during which scans of a failing host are short-circuited (see BinlogScanCircuitBreakerFailures)
+	BinlogMatchFetchRetries                     uint                        // Mid-match, number of times to retry fetching a chunk of events which failed on a transient (e.g. network) error, before failing the match. 0 disables
+	BinlogMatchRecordingDirectory               string                      // When non-empty, every binlog coordinates match records the chunks of events fetched from both instances onto a file in this directory, for offline replay (see ReplayBinlogCoordinatesMatch)
 }
 
 var Config *Configuration = NewConfiguration()
 
 func NewConfiguration() *Configuration {
 	return &Configuration{
-		ListenAddress:                              ":3000",
-		MySQLOrchestratorPort:                      3306,
-		MySQLTopologyMaxPoolConnections:            3,
-		MySQLConnectTimeoutSeconds:                 5,
-		DefaultInstancePort:                        3306,
-		InstancePollSeconds:                        60,
-		UnseenInstanceForgetHours:                  240,
-		SlaveStartPostWaitMilliseconds:             1000,
-		DiscoverByShowSlaveHosts:                   false,
-		DiscoveryPollSeconds:                       5,
-		InstanceBulkOperationsWaitTimeoutSeconds:   60,
-		ActiveNodeExpireSeconds:                    60,
-		HostnameResolveMethod:                      "cname",
-		ExpiryHostnameResolvesMinutes:              60,
-		RejectHostnameResolvePattern:               "",
-		ReasonableReplicationLagSeconds:            10,
-		ReasonableMaintenanceReplicationLagSeconds: 20,
-		AuditLogFile:                               "",
-		AuditPageSize:                              20,
-		ReadOnly:                                   false,
-		AuthenticationMethod:                       "basic",
-		HTTPAuthUser:                               "",
-		HTTPAuthPassword:                           "",
-		AuthUserHeader:                             "X-Forwarded-User",
-		PowerAuthUsers:                             []string{"*"},
-		ClusterNameToAlias:                         make(map[string]string),
-		ServeAgentsHttp:                            false,
-		AgentsUseSSL:                               false,
-		SSLSkipVerify:                              false,
-		SSLPrivateKeyFile:                          "",
-		SSLCertFile:                                "",
-		HttpTimeoutSeconds:                         60,
-		AgentPollMinutes:                           60,
-		UnseenAgentForgetHours:                     6,
-		StaleSeedFailMinutes:                       60,
-		SeedAcceptableBytesDiff:                    8192,
-		PseudoGTIDPattern:                          "",
+		ListenAddress:                   ":3000",
+		MySQLOrchestratorPort:           3306,
+		MySQLTopologyMaxPoolConnections: 3,
+		MySQLConnectTimeoutSeconds:      5,
+		BinlogConnectTimeoutSeconds:     0,
+		BinlogReaderMethod:              "sql",
+		MysqlbinlogCommand:              "mysqlbinlog",
+		BinlogEventsColumns: BinlogEventsColumns{
+			LogName:   "Log_name",
+			Pos:       "Pos",
+			EndLogPos: "End_log_pos",
+			EventType: "Event_type",
+			Info:      "Info",
+			ServerId:  "Server_id",
+		},
+		DefaultInstancePort:                         3306,
+		InstancePollSeconds:                         60,
+		UnseenInstanceForgetHours:                   240,
+		SlaveStartPostWaitMilliseconds:              1000,
+		DiscoverByShowSlaveHosts:                    false,
+		DiscoveryPollSeconds:                        5,
+		InstanceBulkOperationsWaitTimeoutSeconds:    60,
+		ActiveNodeExpireSeconds:                     60,
+		HostnameResolveMethod:                       "cname",
+		ExpiryHostnameResolvesMinutes:               60,
+		RejectHostnameResolvePattern:                "",
+		ReasonableReplicationLagSeconds:             10,
+		ReasonableMaintenanceReplicationLagSeconds:  20,
+		AuditLogFile:                                "",
+		AuditPageSize:                               20,
+		ReadOnly:                                    false,
+		AuthenticationMethod:                        "basic",
+		HTTPAuthUser:                                "",
+		HTTPAuthPassword:                            "",
+		AuthUserHeader:                              "X-Forwarded-User",
+		PowerAuthUsers:                              []string{"*"},
+		ClusterNameToAlias:                          make(map[string]string),
+		ServeAgentsHttp:                             false,
+		AgentsUseSSL:                                false,
+		SSLSkipVerify:                               false,
+		SSLPrivateKeyFile:                           "",
+		SSLCertFile:                                 "",
+		HttpTimeoutSeconds:                          60,
+		AgentPollMinutes:                            60,
+		UnseenAgentForgetHours:                      6,
+		StaleSeedFailMinutes:                        60,
+		SeedAcceptableBytesDiff:                     8192,
+		PseudoGTIDInjectionStatement:                "",
+		PseudoGTIDPattern:                           "",
+		PseudoGTIDCompareCanonicalSQL:               false,
+		PseudoGTIDMatchInterchangeableCommitMarkers: false,
+		PseudoGTIDMatchQueryEventsOnly:              false,
+		PseudoGTIDTimestampPattern:                  "",
+		PseudoGTIDTimestampHexadecimal:              false,
+		PseudoGTIDCacheVerifyMinutes:                0,
+		VerifyPseudoGTIDCacheHits:                   false,
+		PseudoGTIDCacheWarmMinutes:                  0,
+		PseudoGTIDCacheBucketPattern:                "",
+		RelayLogScanSafetyMarginBytes:               0,
+		RelayLogScanStrictExecutedBound:             false,
+		PseudoGTIDMatchIgnorePatterns:               []string{},
+		PseudoGTIDMatchMaxFallbackAnchors:           0,
+		PseudoGTIDSearchMaxBinlogs:                  0,
+		PseudoGTIDFreshBinlogsWaitSeconds:           0,
+		PseudoGTIDMatchMismatchLookahead:            0,
+		BinlogMatchTrace:                            false,
+		BinlogEventsInfoTruncationLength:            0,
+		BinlogEventsTruncatedInfoFallback:           false,
+		BinlogEventsStrictPositions:                 false,
+		BinlogMatchSelfCoordinatesTolerance:         0,
+		SkipMatchSelfCoordinatesCheck:               false,
+		BinlogScanVerifyEndOfLog:                    false,
+		BinlogScanCredentials:                       make(map[string]MySQLCredentials),
+		BinlogFilesDirectories:                      make(map[string]string),
+		BinlogServers:                               make(map[string]string),
+		RelayLogFilesDirectories:                    make(map[string]string),
+		BinlogScanAllowedHostnames:                  []string{},
+		BinlogScanDeniedHostnames:                   []string{},
+		BinlogScanCircuitBreakerFailures:            0,
+		BinlogScanCircuitBreakerCooldownSeconds:     60,
+		BinlogMatchFetchRetries:                     3,
 	}
 }
 
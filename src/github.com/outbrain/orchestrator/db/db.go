@@ -247,13 +247,23 @@ var generateSQLPatches = []string{
 
 // OpenTopology returns a DB instance to access a topology instance
 func OpenTopology(host string, port int) (*sql.DB, error) {
-	mysql_uri := fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%ds", config.Config.MySQLTopologyUser, config.Config.MySQLTopologyPassword, host, port, config.Config.MySQLConnectTimeoutSeconds)
-	db, _, err := sqlutils.GetDB(mysql_uri)
+	return OpenTopologyAs(host, port, config.Config.MySQLTopologyUser, config.Config.MySQLTopologyPassword, config.Config.MySQLConnectTimeoutSeconds)
+}
+
+// OpenTopologyAs returns a DB instance to access a topology instance using explicitly given credentials
+// and connect timeout
+func OpenTopologyAs(host string, port int, user string, password string, connectTimeoutSeconds int) (*sql.DB, error) {
+	db, _, err := sqlutils.GetDB(getTopologyURI(host, port, user, password, connectTimeoutSeconds))
 	db.SetMaxOpenConns(config.Config.MySQLTopologyMaxPoolConnections)
 	db.SetMaxIdleConns(config.Config.MySQLTopologyMaxPoolConnections)
 	return db, err
 }
 
+// getTopologyURI returns the DSN by which to connect to a topology instance
+func getTopologyURI(host string, port int, user string, password string, connectTimeoutSeconds int) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%ds", user, password, host, port, connectTimeoutSeconds)
+}
+
 // OpenTopology returns the DB instance for the orchestrator backed database
 func OpenOrchestrator() (*sql.DB, error) {
 	mysql_uri := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=%ds", config.Config.MySQLOrchestratorUser, config.Config.MySQLOrchestratorPassword,
@@ -0,0 +1,43 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	. "gopkg.in/check.v1"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestTopologyURI(c *C) {
+	uri := getTopologyURI("sql00.db", 3306, "scanner", "s3cret", 7)
+
+	tokens := strings.SplitN(uri, "?", 2)
+	c.Assert(len(tokens), Equals, 2)
+	c.Assert(tokens[0], Equals, "scanner:s3cret@tcp(sql00.db:3306)/")
+
+	// Only params understood by go-sql-driver/mysql are allowed; it rejects e.g. "compress"
+	params, err := url.ParseQuery(tokens[1])
+	c.Assert(err, IsNil)
+	c.Assert(params, DeepEquals, url.Values{"timeout": []string{"7s"}})
+}
@@ -417,12 +417,73 @@ func (this *HttpAPI) LastPseudoGTID(params martini.Params, r render.Render, req
 		return
 	}
 
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("%+v", *coordinates), Details: text})
+}
+
+// LastPseudoGTIDEntry attempts to find the last pseugo-gtid entry in an instance, and returns both its coordinates
+// and text. This differs from LastPseudoGTID, which only returns the text.
+func (this *HttpAPI) LastPseudoGTIDEntry(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	instanceKey, err := this.getInstanceKey(params["host"], params["port"])
 	if err != nil {
 		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
 	}
 
-	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("%+v", *coordinates), Details: text})
+	instance, err := inst.ReadTopologyInstance(&instanceKey)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if instance == nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Instance not found: %+v", instanceKey)})
+		return
+	}
+	coordinates, text, err := inst.FindLastPseudoGTIDEntry(instance, instance.RelaylogCoordinates)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("%+v", *coordinates), Details: inst.PseudoGTIDEntry{Coordinates: *coordinates, Text: text}})
+}
+
+// SearchPseudoGTID attempts to find the coordinates of a given pseugo-gtid entry (provided as "entry" query param) in an instance
+func (this *HttpAPI) SearchPseudoGTID(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	instanceKey, err := this.getInstanceKey(params["host"], params["port"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	entryText := req.URL.Query().Get("entry")
+	if entryText == "" {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Expected entry"})
+		return
+	}
+
+	instance, err := inst.ReadTopologyInstance(&instanceKey)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if instance == nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Instance not found: %+v", instanceKey)})
+		return
+	}
+	coordinates, err := inst.SearchPseudoGTIDEntryInInstance(instance, entryText)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("%+v", *coordinates), Details: inst.PseudoGTIDEntry{Coordinates: *coordinates, Text: entryText}})
 }
 
 // MatchBelow attempts to move an instance below another via pseudo GTID matching of binlog entries
@@ -451,6 +512,85 @@ func (this *HttpAPI) MatchBelow(params martini.Params, r render.Render, req *htt
 	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("Instance %+v matched below %+v at %+v", instanceKey, belowKey, *matchedCoordinates), Details: instance})
 }
 
+// MatchBelowByCoordinates computes the coordinates at which an instance would replicate from another, given explicit
+// twin coordinates of a shared Pseudo-GTID entry on both. Neither instance is modified; replication on the instance
+// is expected to be stopped.
+func (this *HttpAPI) MatchBelowByCoordinates(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	instanceKey, err := this.getInstanceKey(params["host"], params["port"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	belowKey, err := this.getInstanceKey(params["belowHost"], params["belowPort"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	instanceCoordinates, err := inst.ParseBinlogCoordinates(params["coordinates"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	belowCoordinates, err := inst.ParseBinlogCoordinates(params["belowCoordinates"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	instance, err := inst.ReadTopologyInstance(&instanceKey)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if instance == nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Instance not found: %+v", instanceKey)})
+		return
+	}
+	belowInstance, err := inst.ReadTopologyInstance(&belowKey)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	matchedCoordinates, err := inst.MatchBelowByCoordinates(instance, *instanceCoordinates, instance.RelaylogCoordinates, belowInstance, *belowCoordinates)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("Instance %+v would match below %+v at %+v", instanceKey, belowKey, *matchedCoordinates), Details: *matchedCoordinates})
+}
+
+// MatchBelowOnline attempts to move an instance below another via pseudo GTID matching of binlog entries,
+// while keeping the instance replicating for most of the operation
+func (this *HttpAPI) MatchBelowOnline(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	instanceKey, err := this.getInstanceKey(params["host"], params["port"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	belowKey, err := this.getInstanceKey(params["belowHost"], params["belowPort"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	instance, matchedCoordinates, err := inst.MatchBelowOnline(&instanceKey, &belowKey, true, true)
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("Instance %+v matched below %+v online at %+v", instanceKey, belowKey, *matchedCoordinates), Details: instance})
+}
+
 // MultiMatchSlaves attempts to match all slaves of a given instance below another, efficiently
 func (this *HttpAPI) MultiMatchSlaves(params martini.Params, r render.Render, req *http.Request, user auth.User) {
 	if !this.isAuthorizedForAction(req, user) {
@@ -802,6 +942,44 @@ func (this *HttpAPI) ResetHostnameResolveCache(params martini.Params, r render.R
 	r.JSON(200, &APIResponse{Code: OK, Message: "Hostname cache cleared"})
 }
 
+// ClearPseudoGTIDCache removes cached Pseudo-GTID entry coordinates of a given instance
+func (this *HttpAPI) ClearPseudoGTIDCache(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	instanceKey, err := this.getInstanceKey(params["host"], params["port"])
+	if err != nil {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	removedCount := inst.ClearPseudoGTIDCacheForInstance(&instanceKey)
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("Cleared %d pseudo GTID cache entries of %+v", removedCount, instanceKey)})
+}
+
+// PauseBinlogScans pauses running binary log scans, as well as any scans started henceforth, until resumed
+func (this *HttpAPI) PauseBinlogScans(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	inst.PauseBinlogScans()
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("Binlog scans paused; %d active scans", inst.ActiveBinlogScansCount())})
+}
+
+// ResumeBinlogScans resumes binary log scans paused via PauseBinlogScans
+func (this *HttpAPI) ResumeBinlogScans(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !this.isAuthorizedForAction(req, user) {
+		r.JSON(200, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	inst.ResumeBinlogScans()
+
+	r.JSON(200, &APIResponse{Code: OK, Message: fmt.Sprintf("Binlog scans resumed; %d active scans", inst.ActiveBinlogScansCount())})
+}
+
 // Agents provides complete list of registered agents (See https://github.com/outbrain/orchestrator-agent)
 func (this *HttpAPI) Agents(params martini.Params, r render.Render, req *http.Request, user auth.User) {
 	if !this.isAuthorizedForAction(req, user) {
@@ -1151,7 +1329,11 @@ func (this *HttpAPI) RegisterRequests(m *martini.ClassicMartini) {
 	m.Get("/api/move-below/:host/:port/:siblingHost/:siblingPort", this.MoveBelow)
 	m.Get("/api/enslave-siblings-simple/:host/:port", this.EnslaveSiblingsSimple)
 	m.Get("/api/last-pseudo-gtid/:host/:port", this.LastPseudoGTID)
+	m.Get("/api/last-pseudo-gtid-entry/:host/:port", this.LastPseudoGTIDEntry)
+	m.Get("/api/search-pseudo-gtid/:host/:port", this.SearchPseudoGTID)
 	m.Get("/api/match-below/:host/:port/:belowHost/:belowPort", this.MatchBelow)
+	m.Get("/api/match-below-online/:host/:port/:belowHost/:belowPort", this.MatchBelowOnline)
+	m.Get("/api/match-below-by-coordinates/:host/:port/:coordinates/:belowHost/:belowPort/:belowCoordinates", this.MatchBelowByCoordinates)
 	m.Get("/api/multi-match-slaves/:host/:port/:belowHost/:belowPort", this.MultiMatchSlaves)
 	m.Get("/api/match-up-slaves/:host/:port", this.MatchUpSlaves)
 	m.Get("/api/make-master/:host/:port", this.MakeMaster)
@@ -1179,6 +1361,9 @@ func (this *HttpAPI) RegisterRequests(m *martini.ClassicMartini) {
 	m.Get("/api/audit", this.Audit)
 	m.Get("/api/audit/:page", this.Audit)
 	m.Get("/api/reset-hostname-resolve-cache", this.ResetHostnameResolveCache)
+	m.Get("/api/clear-pseudo-gtid-cache/:host/:port", this.ClearPseudoGTIDCache)
+	m.Get("/api/pause-binlog-scans", this.PauseBinlogScans)
+	m.Get("/api/resume-binlog-scans", this.ResumeBinlogScans)
 	// Agents
 	m.Get("/api/agents", this.Agents)
 	m.Get("/api/agent/:host", this.Agent)
@@ -0,0 +1,42 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+const (
+	FlavorMySQL   = "MySQL"
+	FlavorMariaDB = "MariaDB"
+	FlavorPercona = "Percona"
+)
+
+// Capabilities describes server capabilities on which binary log operations depend. They are probed once per
+// instance, and again upon a version change (see ServerCapabilities).
+type Capabilities struct {
+	Key     InstanceKey
+	Version string
+	Flavor  string
+	// GTIDMode is the value of @@global.gtid_mode, or "OFF" on servers not supporting Oracle GTID
+	GTIDMode string
+	// RelaylogEventsSupported is true when the server supports SHOW RELAYLOG EVENTS
+	RelaylogEventsSupported bool
+	// EndLogPosAccurate is true when End_log_pos of the server's binary log events points to the next event
+	EndLogPosAccurate bool
+}
+
+// UsingOracleGTID returns true when Oracle GTID is enabled on the server
+func (this *Capabilities) UsingOracleGTID() bool {
+	return this.GTIDMode == "ON"
+}
@@ -0,0 +1,124 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator/db"
+	"github.com/pmylund/go-cache"
+	"strings"
+	"time"
+)
+
+// capabilitiesCache holds probed capabilities per instance. Capabilities are not shared by servers of the same
+// version: gtid_mode is per server configuration, and End_log_pos accuracy is probed on the server's own binary logs.
+var capabilitiesCache = cache.New(time.Hour, time.Minute)
+
+// getCapabilitiesKey keys cached capabilities by instance; the version is part of the key, such that an upgraded
+// server is probed anew
+func getCapabilitiesKey(instance *Instance) string {
+	return fmt.Sprintf("%s;%s", instance.Key.DisplayString(), instance.Version)
+}
+
+// ServerCapabilities returns the capabilities of given instance, probing the server on first call for the
+// instance (and again once its version changes), and returning cached results thereafter.
+func ServerCapabilities(instance *Instance) (*Capabilities, error) {
+	if capabilities, found := capabilitiesCache.Get(getCapabilitiesKey(instance)); found {
+		return capabilities.(*Capabilities), nil
+	}
+	capabilities, err := probeCapabilities(instance)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	capabilitiesCache.Set(getCapabilitiesKey(instance), capabilities, 0)
+	return capabilities, nil
+}
+
+// ForgetServerCapabilities removes cached capabilities of given instance, such that they are probed on next call
+func ForgetServerCapabilities(instance *Instance) {
+	capabilitiesCache.Delete(getCapabilitiesKey(instance))
+}
+
+func probeCapabilities(instance *Instance) (*Capabilities, error) {
+	capabilities := &Capabilities{Key: instance.Key, Version: instance.Version}
+
+	db, err := db.OpenTopology(instance.Key.Hostname, instance.Key.Port)
+	if err != nil {
+		return nil, err
+	}
+	var versionComment string
+	if err := db.QueryRow("select @@global.version_comment").Scan(&versionComment); err != nil {
+		return nil, err
+	}
+	capabilities.Flavor = FlavorMySQL
+	if strings.Contains(instance.Version, "MariaDB") || strings.Contains(versionComment, "MariaDB") {
+		capabilities.Flavor = FlavorMariaDB
+	} else if strings.Contains(versionComment, "Percona") {
+		capabilities.Flavor = FlavorPercona
+	}
+
+	capabilities.GTIDMode = "OFF"
+	if err := db.QueryRow("select @@global.gtid_mode").Scan(&capabilities.GTIDMode); err != nil {
+		if _, ok := err.(*mysql.MySQLError); !ok {
+			return nil, err
+		}
+		// Unknown system variable: no Oracle GTID on this server
+	}
+
+	if capabilities.RelaylogEventsSupported, err = probeRelaylogEventsSupported(db); err != nil {
+		return nil, err
+	}
+	if capabilities.EndLogPosAccurate, err = probeEndLogPosAccurate(instance); err != nil {
+		return nil, err
+	}
+	return capabilities, nil
+}
+
+// probeRelaylogEventsSupported checks whether the server accepts SHOW RELAYLOG EVENTS. Servers which do not
+// support the statement respond with a parse error.
+func probeRelaylogEventsSupported(db *sql.DB) (bool, error) {
+	rows, err := db.Query("show relaylog events limit 0")
+	if err == nil {
+		rows.Close()
+		return true, nil
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		// Any other error (e.g. no relay logs on this server) means the statement itself is supported
+		return mysqlErr.Number != mysqlErrorParse, nil
+	}
+	return false, err
+}
+
+// probeEndLogPosAccurate checks, on the instance's latest binary log, that each event's End_log_pos points to the
+// following event. An instance with no binary logs is assumed to be accurate.
+func probeEndLogPosAccurate(instance *Instance) (bool, error) {
+	binlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	if len(binlogs) == 0 {
+		return true, nil
+	}
+	events, err := PeekBinlogEvents(&instance.Key, BinlogCoordinates{LogFile: binlogs[len(binlogs)-1], LogPos: 0, Type: BinaryLog}, 2)
+	if err != nil {
+		return false, err
+	}
+	if len(events) < 2 {
+		return true, nil
+	}
+	return events[0].NextEventPos == events[1].Coordinates.LogPos, nil
+}
@@ -132,6 +132,64 @@ func (this *BinlogCoordinates) PreviousFileCoordinates() (BinlogCoordinates, err
 	return result, nil
 }
 
+// NextFileCoordinates guesses the filename of the next binlog/relaylog
+func (this *BinlogCoordinates) NextFileCoordinates() (BinlogCoordinates, error) {
+	result := BinlogCoordinates{LogPos: 0, Type: this.Type}
+
+	tokens := strings.Split(this.LogFile, ".")
+	numPart := tokens[len(tokens)-1]
+	numLen := len(numPart)
+	fileNum, err := strconv.Atoi(numPart)
+	if err != nil {
+		return result, err
+	}
+	newNumStr := fmt.Sprintf("%d", (fileNum + 1))
+	if len(newNumStr) < numLen {
+		newNumStr = strings.Repeat("0", numLen-len(newNumStr)) + newNumStr
+	}
+	tokens[len(tokens)-1] = newNumStr
+	result.LogFile = strings.Join(tokens, ".")
+	return result, nil
+}
+
+// String returns a compact "file:pos:type" representation of these coordinates, where type is either "binlog"
+// or "relaylog". It is parsable by ParseBinlogCoordinates.
+func (this *BinlogCoordinates) String() string {
+	typeToken := "binlog"
+	if this.Type == RelayLog {
+		typeToken = "relaylog"
+	}
+	return fmt.Sprintf("%s:%d:%s", this.LogFile, this.LogPos, typeToken)
+}
+
+// ParseBinlogCoordinates parses a "file:pos[:type]" string, as generated by String(), into coordinates.
+// type is either "binlog" or "relaylog"; when omitted, binary log coordinates are assumed.
+func ParseBinlogCoordinates(coordinatesString string) (*BinlogCoordinates, error) {
+	tokens := strings.Split(coordinatesString, ":")
+	if len(tokens) != 2 && len(tokens) != 3 {
+		return nil, errors.New(fmt.Sprintf("ParseBinlogCoordinates: cannot parse %s", coordinatesString))
+	}
+	if tokens[0] == "" {
+		return nil, errors.New(fmt.Sprintf("ParseBinlogCoordinates: empty log file in %s", coordinatesString))
+	}
+	logPos, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ParseBinlogCoordinates: invalid log position in %s", coordinatesString))
+	}
+	coordinates := &BinlogCoordinates{LogFile: tokens[0], LogPos: logPos, Type: BinaryLog}
+	if len(tokens) == 3 {
+		switch tokens[2] {
+		case "binlog":
+			coordinates.Type = BinaryLog
+		case "relaylog":
+			coordinates.Type = RelayLog
+		default:
+			return nil, errors.New(fmt.Sprintf("ParseBinlogCoordinates: invalid log type in %s", coordinatesString))
+		}
+	}
+	return coordinates, nil
+}
+
 // DisplayString returns a user-friendly string representation of these coordinates
 func (this *BinlogCoordinates) DisplayString() string {
 	return fmt.Sprintf("%s:%d", this.LogFile, this.LogPos)
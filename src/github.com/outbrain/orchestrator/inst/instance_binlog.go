@@ -17,8 +17,18 @@
 package inst
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator/config"
+	"github.com/pmylund/go-cache"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 // Event entries may contains table IDs (can be different for same tables on different servers)
@@ -37,11 +47,265 @@ var skippedEventTypes map[string]bool = map[string]bool{
 	"Rotate":      true,
 }
 
+// transactionContextEventTypes are events which only make sense within the context of the statement following them
+var transactionContextEventTypes map[string]bool = map[string]bool{
+	"Intvar":   true,
+	"RAND":     true,
+	"User var": true,
+}
+
+// tableIdRegexp extracts the table id from the Info of Table_map and rows events
+var tableIdRegexp = regexp.MustCompile(`^table_id: ([0-9]+)`)
+
+// gtidEventInfoRegexps parse the Info of GTID events: Oracle's "SET @@SESSION.GTID_NEXT= 'uuid:gno'" and
+// MariaDB's "BEGIN GTID domain-server-seq"
+var gtidEventInfoRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`^SET @@SESSION.GTID_NEXT= '([0-9a-fA-F-]+:[0-9]+)'`),
+	regexp.MustCompile(`^BEGIN GTID ([0-9]+-[0-9]+-[0-9]+)`),
+}
+
+// rotateEventInfoRegexp parses the Info of a Rotate event, e.g. "mysql-bin.000019;pos=4"
+var rotateEventInfoRegexp = regexp.MustCompile(`^(.+);pos=([0-9]+)$`)
+
 type BinlogEvent struct {
 	Coordinates  BinlogCoordinates
 	NextEventPos int64
 	EventType    string
 	Info         string
+	// Timestamp is the event's header timestamp. SHOW BINLOG EVENTS does not provide it, hence it is only set by
+	// readers parsing the logs themselves (see BinlogFilesDirectories, BinlogReaderMethod); otherwise it is zero.
+	Timestamp time.Time
+	ServerId  uint
+	// Flags are the event's header flags. SHOW BINLOG EVENTS and mysqlbinlog do not provide them, hence these are
+	// only set when binary logs are read off disk (see BinlogFilesDirectories); otherwise zero.
+	Flags uint16
+}
+
+// logEventArtificialFlag marks events created by the server rather than replicated (e.g. a slave's fake Rotate)
+const logEventArtificialFlag uint16 = 0x20
+
+// IsArtificial returns true for events created by the server itself, rather than logged upon a write. Only known
+// when Flags are available.
+func (this *BinlogEvent) IsArtificial() bool {
+	return this.Flags&logEventArtificialFlag != 0
+}
+
+// truncatedInfoSuffix is the ellipsis with which a server may cut short a long statement in SHOW BINLOG EVENTS' Info
+const truncatedInfoSuffix = "..."
+
+// IsInfoTruncated heuristically tells whether this event's Info was truncated by SHOW BINLOG EVENTS: it ends with an
+// ellipsis, or is at least BinlogEventsInfoTruncationLength long. Matching such events is unreliable when servers
+// truncate differently.
+func (this *BinlogEvent) IsInfoTruncated() bool {
+	if strings.HasSuffix(this.Info, truncatedInfoSuffix) {
+		return true
+	}
+	return config.Config.BinlogEventsInfoTruncationLength > 0 && len(this.Info) >= config.Config.BinlogEventsInfoTruncationLength
+}
+
+// averageBinlogEventSizeBytes is a rough, generic estimate of a binlog event's size, used for scan cost estimation
+const averageBinlogEventSizeBytes int64 = 256
+
+// BinaryLogFile describes a binary log file as listed by SHOW BINARY LOGS
+type BinaryLogFile struct {
+	LogFile string
+	Size    int64
+}
+
+// ComputeBinlogLagBytes computes the number of binary log bytes between given slave coordinates and given master
+// coordinates, both in terms of the master's binary logs, listed (in order) with their sizes in binaryLogFiles.
+// Coordinates may be files apart, in which case the remainder of the slave's binary log, all binary logs in between,
+// and the master's binary log up to its position, are summed up.
+func ComputeBinlogLagBytes(masterCoordinates BinlogCoordinates, slaveCoordinates BinlogCoordinates, binaryLogFiles []BinaryLogFile) (int64, error) {
+	if masterCoordinates.LogFile == slaveCoordinates.LogFile {
+		if masterCoordinates.LogPos < slaveCoordinates.LogPos {
+			return 0, nil
+		}
+		return masterCoordinates.LogPos - slaveCoordinates.LogPos, nil
+	}
+	var lagBytes int64
+	slaveFileFound := false
+	for _, binaryLogFile := range binaryLogFiles {
+		switch {
+		case binaryLogFile.LogFile == slaveCoordinates.LogFile:
+			slaveFileFound = true
+			lagBytes += binaryLogFile.Size - slaveCoordinates.LogPos
+		case binaryLogFile.LogFile == masterCoordinates.LogFile:
+			if !slaveFileFound {
+				return 0, errors.New(fmt.Sprintf("Binary log %s not found before %s; purged, or ahead of master", slaveCoordinates.LogFile, masterCoordinates.LogFile))
+			}
+			return lagBytes + masterCoordinates.LogPos, nil
+		case slaveFileFound:
+			lagBytes += binaryLogFile.Size
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Binary log %s not found", masterCoordinates.LogFile))
+}
+
+// BinlogTimeRange is the time span covered by a binary log, as per its events' timestamps
+type BinlogTimeRange struct {
+	LogFile             string
+	FirstEventTimestamp time.Time
+	LastEventTimestamp  time.Time // Zero for the current binary log, which is still being written to
+}
+
+// BinlogCoordinatesMatchResult is the outcome of matching an instance's binlog events against those of another instance
+type BinlogCoordinatesMatchResult struct {
+	TargetCoordinates               BinlogCoordinates // Coordinates on the other instance at which instance should resume replication
+	TargetGTID                      string            // GTID of the event at TargetCoordinates, when that is a GTID event (GTID enabled other instance); empty otherwise
+	InstanceLastConsumedCoordinates BinlogCoordinates // Coordinates of the last event consumed on instance
+	InstanceEndedAtSelfCoordinates  bool              // Whether the instance's scan was verified to end at its own current coordinates (master status or relay log position)
+}
+
+// BinlogScanCostEstimate is a rough estimate of the work involved in a worst case scan of an instance's binary logs
+type BinlogScanCostEstimate struct {
+	InstanceKey     InstanceKey
+	NumBinlogs      int
+	TotalBytes      int64
+	EstimatedEvents int64
+}
+
+// NewBinlogScanCostEstimate computes a worst case scan estimate over given binary log files
+func NewBinlogScanCostEstimate(instanceKey *InstanceKey, binaryLogFiles []BinaryLogFile) *BinlogScanCostEstimate {
+	estimate := &BinlogScanCostEstimate{InstanceKey: *instanceKey, NumBinlogs: len(binaryLogFiles)}
+	for _, binaryLogFile := range binaryLogFiles {
+		estimate.TotalBytes += binaryLogFile.Size
+	}
+	estimate.EstimatedEvents = estimate.TotalBytes / averageBinlogEventSizeBytes
+	return estimate
+}
+
+const (
+	MatchStrategyGTID       = "GTID"
+	MatchStrategyPseudoGTID = "Pseudo-GTID"
+	MatchStrategyNone       = "None"
+)
+
+// MatchRecommendation recommends a strategy for repositioning an instance below another, along with the rationale
+type MatchRecommendation struct {
+	Strategy                  string
+	GTIDAvailable             bool
+	PseudoGTIDAvailable       bool
+	PseudoGTIDEstimatedEvents int64 // Worst case number of events a Pseudo-GTID match would scan
+	Rationale                 string
+}
+
+// BinlogScanProgress reports the progress of a long running binary log scan
+type BinlogScanProgress struct {
+	RowsScanned        int64
+	Elapsed            time.Duration
+	EstimatedTotalRows int64         // Worst case estimate of rows to scan, based on binary log sizes; 0 when unknown
+	EstimatedRemaining time.Duration // Based on throughput so far; 0 when unknown
+}
+
+// NewBinlogScanProgress computes scan progress, estimating the remaining time by throughput so far. As the total
+// is a worst case estimate (the scan may well end early), so is the remaining time.
+func NewBinlogScanProgress(rowsScanned int64, elapsed time.Duration, estimatedTotalRows int64) BinlogScanProgress {
+	progress := BinlogScanProgress{RowsScanned: rowsScanned, Elapsed: elapsed, EstimatedTotalRows: estimatedTotalRows}
+	if rowsScanned > 0 && estimatedTotalRows > rowsScanned {
+		progress.EstimatedRemaining = time.Duration(float64(elapsed) * float64(estimatedTotalRows-rowsScanned) / float64(rowsScanned))
+	}
+	return progress
+}
+
+// PseudoGTIDInterval describes the typical interval between consecutive Pseudo-GTID entries
+type PseudoGTIDInterval struct {
+	EntriesSampled   int
+	PositionInterval int64         // Average distance, in bytes, between consecutive entries within the same binary log
+	TimeInterval     time.Duration // Average time between consecutive entries; 0 when entries hold no parsable timestamp
+}
+
+// NewPseudoGTIDInterval computes the interval between given entries, which are expected in ascending order
+func NewPseudoGTIDInterval(entries []PseudoGTIDEntry) *PseudoGTIDInterval {
+	interval := &PseudoGTIDInterval{EntriesSampled: len(entries)}
+	var positionIntervalsSum, positionIntervalsCount int64
+	var timeIntervalsSum time.Duration
+	var timeIntervalsCount int64
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Coordinates.LogFile == entries[i-1].Coordinates.LogFile {
+			positionIntervalsSum += entries[i].Coordinates.LogPos - entries[i-1].Coordinates.LogPos
+			positionIntervalsCount++
+		}
+		previousTime, err := ParsePseudoGTIDTimestamp(entries[i-1].Text)
+		if err != nil {
+			continue
+		}
+		currentTime, err := ParsePseudoGTIDTimestamp(entries[i].Text)
+		if err != nil {
+			continue
+		}
+		timeIntervalsSum += currentTime.Sub(previousTime)
+		timeIntervalsCount++
+	}
+	if positionIntervalsCount > 0 {
+		interval.PositionInterval = positionIntervalsSum / positionIntervalsCount
+	}
+	if timeIntervalsCount > 0 {
+		interval.TimeInterval = timeIntervalsSum / time.Duration(timeIntervalsCount)
+	}
+	return interval
+}
+
+// BinlogCoordinatesAtOffset maps a cumulative byte offset across given binary logs (taken in given order) onto
+// the coordinates it falls into. An offset equal to the total size maps onto the end of the last binary log.
+func BinlogCoordinatesAtOffset(binaryLogFiles []BinaryLogFile, offset int64) (*BinlogCoordinates, error) {
+	if offset < 0 {
+		return nil, errors.New(fmt.Sprintf("Negative binlog offset: %d", offset))
+	}
+	remaining := offset
+	for i, binaryLogFile := range binaryLogFiles {
+		if remaining < binaryLogFile.Size || (remaining == binaryLogFile.Size && i == len(binaryLogFiles)-1) {
+			return &BinlogCoordinates{LogFile: binaryLogFile.LogFile, LogPos: remaining, Type: BinaryLog}, nil
+		}
+		remaining -= binaryLogFile.Size
+	}
+	return nil, errors.New(fmt.Sprintf("Binlog offset %d exceeds total binlog size %d", offset, offset-remaining))
+}
+
+// binlogFileNames sorts binary/relay log file names by their sequence: by base name, then by numeric extension.
+// Plain lexical ordering does not suffice, since the extension may outgrow its zero padding (e.g. mysql-bin.999999
+// is followed by mysql-bin.1000000).
+type binlogFileNames []string
+
+func (this binlogFileNames) Len() int      { return len(this) }
+func (this binlogFileNames) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this binlogFileNames) Less(i, j int) bool {
+	iBaseName, iExtension := splitBinlogFileName(this[i])
+	jBaseName, jExtension := splitBinlogFileName(this[j])
+	if iBaseName != jBaseName {
+		return iBaseName < jBaseName
+	}
+	iNumber, iErr := strconv.ParseInt(iExtension, 10, 64)
+	jNumber, jErr := strconv.ParseInt(jExtension, 10, 64)
+	if iErr != nil || jErr != nil {
+		return iExtension < jExtension
+	}
+	return iNumber < jNumber
+}
+
+// binlogFileNameSmallerThan compares binary/relay log file names by their numeric suffix, as SortBinaryLogs does
+func binlogFileNameSmallerThan(logFile string, otherLogFile string) bool {
+	return binlogFileNames{logFile, otherLogFile}.Less(0, 1)
+}
+
+func splitBinlogFileName(logFile string) (string, string) {
+	index := strings.LastIndex(logFile, ".")
+	return logFile[0 : index+1], logFile[index+1:]
+}
+
+// SortBinaryLogs returns a copy of given binary/relay log file names, sorted in ascending sequence. Scans which
+// walk the logs newest-first rely on this order.
+func SortBinaryLogs(binlogs []string) []string {
+	sortedBinlogs := make([]string, len(binlogs))
+	copy(sortedBinlogs, binlogs)
+	sort.Sort(binlogFileNames(sortedBinlogs))
+	return sortedBinlogs
+}
+
+// PseudoGTIDEntry is a Pseudo-GTID entry found in binary/relay logs, along with its coordinates
+type PseudoGTIDEntry struct {
+	Coordinates BinlogCoordinates
+	Text        string
 }
 
 //
@@ -49,6 +313,196 @@ func (this *BinlogEvent) NextBinlogCoordinates() BinlogCoordinates {
 	return BinlogCoordinates{LogFile: this.Coordinates.LogFile, LogPos: this.NextEventPos, Type: this.Coordinates.Type}
 }
 
+// normalizeNextEventPos fixes NextEventPos in binary logs larger than 4GB. End_log_pos is a 32 bit event header field,
+// and wraps around past 4GB, whereas event positions (as listed by SHOW BINLOG EVENTS) do not. Since a single event
+// is smaller than 4GB, the true end position is the first one past the event's position which agrees with the
+// header's value on the lower 32 bits.
+// Relay log events are left untouched: their End_log_pos refers to the master's binary log.
+func (this *BinlogEvent) normalizeNextEventPos() {
+	if this.Coordinates.Type != BinaryLog || this.NextEventPos <= 0 {
+		return
+	}
+	if this.NextEventPos > this.Coordinates.LogPos {
+		return
+	}
+	this.NextEventPos = this.Coordinates.LogPos + (this.NextEventPos-this.Coordinates.LogPos)&0xFFFFFFFF
+}
+
+// RotateCoordinates returns the coordinates a Rotate event points to, or nil if this is not a Rotate event
+func (this *BinlogEvent) RotateCoordinates() *BinlogCoordinates {
+	if this.EventType != "Rotate" {
+		return nil
+	}
+	submatch := rotateEventInfoRegexp.FindStringSubmatch(this.Info)
+	if len(submatch) == 0 {
+		return nil
+	}
+	logPos, err := strconv.ParseInt(submatch[2], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &BinlogCoordinates{LogFile: submatch[1], LogPos: logPos, Type: BinaryLog}
+}
+
+// ParsedGTID returns the GTID of a GTID event, or an empty string if this is not a GTID event
+func (this *BinlogEvent) ParsedGTID() string {
+	if this.EventType != "Gtid" {
+		return ""
+	}
+	for _, gtidEventInfoRegexp := range gtidEventInfoRegexps {
+		if submatch := gtidEventInfoRegexp.FindStringSubmatch(this.Info); len(submatch) > 0 {
+			return submatch[1]
+		}
+	}
+	return ""
+}
+
+// ParsePseudoGTIDTimestamp extracts the timestamp embedded in a Pseudo-GTID entry text, as per
+// PseudoGTIDTimestampPattern (and PseudoGTIDTimestampHexadecimal)
+func ParsePseudoGTIDTimestamp(entryText string) (time.Time, error) {
+	if config.Config.PseudoGTIDTimestampPattern == "" {
+		return time.Time{}, errors.New("PseudoGTIDTimestampPattern not configured")
+	}
+	timestampRegexp, err := compilePattern(config.Config.PseudoGTIDTimestampPattern)
+	if err != nil {
+		return time.Time{}, err
+	}
+	submatch := timestampRegexp.FindStringSubmatch(entryText)
+	if len(submatch) < 2 {
+		return time.Time{}, errors.New(fmt.Sprintf("Cannot extract timestamp from pseudo GTID entry: %s", entryText))
+	}
+	base := 10
+	if config.Config.PseudoGTIDTimestampHexadecimal {
+		base = 16
+	}
+	unixTimestamp, err := strconv.ParseInt(submatch[1], base, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unixTimestamp, 0), nil
+}
+
+// pseudoGTIDBenignStatements is a corpus of common binary log entries, as seen by SHOW BINLOG EVENTS, none of which
+// should ever be taken for a Pseudo-GTID entry.
+var pseudoGTIDBenignStatements = []string{
+	"BEGIN",
+	"COMMIT",
+	"COMMIT /* xid=42 */",
+	"ROLLBACK",
+	"use `test`; insert into t (id, name) values (1, 'name')",
+	"use `test`; update t set name = 'name' where id = 1",
+	"use `test`; delete from t where id = 1",
+	"use `test`; replace into t (id) values (1)",
+	"use `test`; create table t (id int unsigned not null primary key)",
+	"use `test`; alter table t add column name varchar(64)",
+	"use `test`; drop table if exists `t` /* generated by server */",
+	"use `test`; truncate table t",
+	"use `test`; create view v as select * from t",
+	"use `test`; drop view if exists `v`",
+	"use `test`; create event e on schedule every 1 second do select 1",
+	"use `test`; grant select on test.* to 'user'@'%'",
+	"use `test`; flush tables",
+	"INSERT_ID=1",
+	"LAST_INSERT_ID=1",
+	"SET @@SESSION.GTID_NEXT= 'ANONYMOUS'",
+	"Server ver: 5.6.25-log, Binlog ver: 4",
+	"mysql-bin.000002;pos=4",
+	"table_id: 42 (test.t)",
+	"table_id: 42 flags: STMT_END_F",
+}
+
+// compiledPatternsCache holds compiled configured patterns (PseudoGTIDPattern and the like), keyed by pattern
+var compiledPatternsCache = cache.New(time.Hour, time.Minute)
+
+// compilePattern returns the compiled regular expression of given configured pattern. Patterns are compiled once,
+// and not upon each scan; they are keyed by text since configuration may be reloaded.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, found := compiledPatternsCache.Get(pattern); found {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatternsCache.Set(pattern, compiled, 0)
+	return compiled, nil
+}
+
+// PseudoGTIDPatternBenignMatches returns the common, benign statements which PseudoGTIDPattern matches. Any such
+// match means the pattern would pick arbitrary events as Pseudo-GTID entries, wrecking matching.
+func PseudoGTIDPatternBenignMatches() ([]string, error) {
+	matches := []string{}
+	if config.Config.PseudoGTIDPattern == "" {
+		return matches, nil
+	}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return matches, err
+	}
+	for _, statement := range pseudoGTIDBenignStatements {
+		if pseudoGTIDRegexp.MatchString(statement) {
+			matches = append(matches, statement)
+		}
+	}
+	return matches, nil
+}
+
+// ValidatePseudoGTIDPattern warns when PseudoGTIDPattern is invalid or matches common, benign statements, or when
+// PseudoGTIDCacheBucketPattern is invalid. Valid patterns are hereby compiled ahead of any scan.
+func ValidatePseudoGTIDPattern() error {
+	if config.Config.PseudoGTIDCacheBucketPattern != "" {
+		if _, err := compilePattern(config.Config.PseudoGTIDCacheBucketPattern); err != nil {
+			return log.Errorf("Invalid PseudoGTIDCacheBucketPattern: %s: %+v", config.Config.PseudoGTIDCacheBucketPattern, err)
+		}
+	}
+	matches, err := PseudoGTIDPatternBenignMatches()
+	if err != nil {
+		return log.Errorf("Invalid PseudoGTIDPattern: %s: %+v", config.Config.PseudoGTIDPattern, err)
+	}
+	for _, statement := range matches {
+		log.Warningf("PseudoGTIDPattern %s matches common statement: %s; Pseudo-GTID matching will be unreliable", config.Config.PseudoGTIDPattern, statement)
+	}
+	if len(matches) > 0 {
+		return errors.New(fmt.Sprintf("PseudoGTIDPattern %s matches %d common statements", config.Config.PseudoGTIDPattern, len(matches)))
+	}
+	return nil
+}
+
+// IsPseudoGTIDCandidate returns false for events which should not be tested as Pseudo-GTID entries. With
+// PseudoGTIDMatchQueryEventsOnly only Query events are candidates, since Pseudo-GTID is injected as a statement;
+// this saves pattern matching over (possibly binary) row event payloads.
+func IsPseudoGTIDCandidate(event *BinlogEvent) bool {
+	if config.Config.PseudoGTIDMatchQueryEventsOnly {
+		return event.EventType == "Query"
+	}
+	return true
+}
+
+// pseudoGTIDCandidateEventTypes returns the event types which may hold a Pseudo-GTID entry, as a hint to binlog
+// readers (see EventTypesFilteringBinlogReader); nil when any event type may.
+func pseudoGTIDCandidateEventTypes() map[string]bool {
+	if config.Config.PseudoGTIDMatchQueryEventsOnly {
+		return map[string]bool{"Query": true}
+	}
+	return nil
+}
+
+// IsRotateToMaster returns true when this is a relay log Rotate event pointing to the master's binary log, as opposed
+// to a Rotate event pointing to the slave's own next relay log
+func (this *BinlogEvent) IsRotateToMaster() bool {
+	if this.Coordinates.Type != RelayLog {
+		return false
+	}
+	rotateCoordinates := this.RotateCoordinates()
+	if rotateCoordinates == nil {
+		return false
+	}
+	logFileBaseName := func(logFile string) string {
+		return logFile[0 : strings.LastIndex(logFile, ".")+1]
+	}
+	return logFileBaseName(rotateCoordinates.LogFile) != logFileBaseName(this.Coordinates.LogFile)
+}
+
 //
 func (this *BinlogEvent) NormalizeInfo() {
 	for reg, replace := range eventInfoTransformations {
@@ -56,12 +510,122 @@ func (this *BinlogEvent) NormalizeInfo() {
 	}
 }
 
+// canonicalSQL returns a canonical form of given statement: whitespace sequences are collapsed into a single space,
+// and any text not within quotes is lowercased. Quoted text (strings, identifiers) is left intact.
+func canonicalSQL(statement string) string {
+	var buffer bytes.Buffer
+	var quote rune = 0
+	escaped := false
+	pendingSpace := false
+	for _, c := range strings.TrimSpace(statement) {
+		if quote != 0 {
+			buffer.WriteRune(c)
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if unicode.IsSpace(c) {
+			pendingSpace = true
+			continue
+		}
+		if pendingSpace {
+			buffer.WriteRune(' ')
+			pendingSpace = false
+		}
+		if c == '\'' || c == '"' || c == '`' {
+			quote = c
+		}
+		buffer.WriteRune(unicode.ToLower(c))
+	}
+	return buffer.String()
+}
+
+// EventInfoEquals compares the Info of two binlog events. Depending on configuration, comparison
+// is either exact or by canonical SQL.
+func EventInfoEquals(info string, otherInfo string) bool {
+	return GetEventComparator().Equal(BinlogEvent{Info: info}, BinlogEvent{Info: otherInfo})
+}
+
+// EventComparator decides whether two binlog events are equal, as far as matching is concerned.
+// It is the extension point for the various comparison strategies (exact, canonical SQL etc.)
+type EventComparator interface {
+	Equal(event BinlogEvent, other BinlogEvent) bool
+}
+
+// ExactEventComparator compares events by exact Info
+type ExactEventComparator struct{}
+
+func (this ExactEventComparator) Equal(event BinlogEvent, other BinlogEvent) bool {
+	return event.Info == other.Info
+}
+
+// CanonicalSQLEventComparator compares events by canonical SQL of their Info
+type CanonicalSQLEventComparator struct{}
+
+func (this CanonicalSQLEventComparator) Equal(event BinlogEvent, other BinlogEvent) bool {
+	return event.Info == other.Info || canonicalSQL(event.Info) == canonicalSQL(other.Info)
+}
+
+// commitStatementRegexp matches a COMMIT statement, as logged in a Query event's Info
+var commitStatementRegexp = regexp.MustCompile(`(?i)^\s*(use\s+\S+;\s*)?COMMIT\s*([/][*].*[*][/])?\s*$`)
+
+// IsCommitMarker returns true for events marking a transaction's commit: an Xid event, or a COMMIT Query event
+func (this *BinlogEvent) IsCommitMarker() bool {
+	switch this.EventType {
+	case "Xid":
+		return true
+	case "Query":
+		return commitStatementRegexp.MatchString(this.Info)
+	}
+	return false
+}
+
+// CommitMarkerTolerantEventComparator compares events via an underlying comparator, further treating any two commit
+// markers as equal. Servers may differ in how they log a commit (Xid event vs. COMMIT Query event), e.g. due to
+// differing storage engines or binlog formats, with no bearing on the transactions themselves.
+type CommitMarkerTolerantEventComparator struct {
+	Comparator EventComparator
+}
+
+func (this CommitMarkerTolerantEventComparator) Equal(event BinlogEvent, other BinlogEvent) bool {
+	if event.IsCommitMarker() && other.IsCommitMarker() {
+		return true
+	}
+	return this.Comparator.Equal(event, other)
+}
+
+// GetEventComparator returns the comparator to use by default, as per configuration: exact Info comparison,
+// unless PseudoGTIDCompareCanonicalSQL is set
+func GetEventComparator() EventComparator {
+	if config.Config.PseudoGTIDCompareCanonicalSQL {
+		return CanonicalSQLEventComparator{}
+	}
+	return ExactEventComparator{}
+}
+
 //
 type BinlogEventCursor struct {
 	cachedEvents      []BinlogEvent
 	currentEventIndex int
 	fetchNextEvents   func(BinlogCoordinates) ([]BinlogEvent, error)
 	nextCoordinates   BinlogCoordinates
+	lastEvent         *BinlogEvent
+	pendingEvents     []BinlogEvent
+	ignoreRegexps     []*regexp.Regexp
+	ignoredTableIds   map[string]bool
+	isRealEvent       func(BinlogEvent) bool
+}
+
+// IsRealBinlogEvent is the default definition of a "real" event, i.e. one which is compared while matching: any
+// event which is not a meta/control event (start-of-binary-log, rotate-binary-log etc.)
+func IsRealBinlogEvent(event BinlogEvent) bool {
+	_, found := skippedEventTypes[event.EventType]
+	return !found
 }
 
 // fetchNextEventsFunc expected to return events starting at a given position, and automatically fetch those from next
@@ -69,29 +633,65 @@ type BinlogEventCursor struct {
 // It is expected to return empty array with no error upon end of binlogs
 // It is expected to return error upon error...
 func NewBinlogEventCursor(startCoordinates BinlogCoordinates, fetchNextEventsFunc func(BinlogCoordinates) ([]BinlogEvent, error)) BinlogEventCursor {
+	return NewBinlogEventCursorWithPredicate(startCoordinates, fetchNextEventsFunc, nil)
+}
+
+// NewBinlogEventCursorWithPredicate creates a cursor whose NextRealEvent() returns those events satisfying
+// given isRealEventFunc. A nil isRealEventFunc stands for IsRealBinlogEvent.
+func NewBinlogEventCursorWithPredicate(startCoordinates BinlogCoordinates, fetchNextEventsFunc func(BinlogCoordinates) ([]BinlogEvent, error), isRealEventFunc func(BinlogEvent) bool) BinlogEventCursor {
+	if isRealEventFunc == nil {
+		isRealEventFunc = IsRealBinlogEvent
+	}
 	events, _ := fetchNextEventsFunc(startCoordinates)
+	ignoreRegexps := []*regexp.Regexp{}
+	for _, pattern := range config.Config.PseudoGTIDMatchIgnorePatterns {
+		if ignoreRegexp, err := compilePattern(pattern); err != nil {
+			log.Errore(err)
+		} else {
+			ignoreRegexps = append(ignoreRegexps, ignoreRegexp)
+		}
+	}
 	return BinlogEventCursor{
 		cachedEvents:      events,
 		currentEventIndex: -1,
 		fetchNextEvents:   fetchNextEventsFunc,
+		ignoreRegexps:     ignoreRegexps,
+		ignoredTableIds:   make(map[string]bool),
+		isRealEvent:       isRealEventFunc,
 	}
 }
 
 // NextEvent will return the next event entry from binary logs; it will automatically skip to next
 // binary log if need be.
 // Internally, it uses the cachedEvents array, so that it does not go to the MySQL server upon each call.
+// Returned events are copies, and remain valid throughout; the cursor itself discards the Info of cached events
+// once consumed, so as to reduce memory footprint of long scans.
 // Returns nil upon reaching end of binary logs.
 func (this *BinlogEventCursor) NextEvent() (*BinlogEvent, error) {
+	if len(this.pendingEvents) > 0 {
+		// Events previously read ahead by NextRealEvent
+		event := this.pendingEvents[0]
+		this.pendingEvents = this.pendingEvents[1:]
+		this.nextCoordinates = event.NextBinlogCoordinates()
+		this.lastEvent = &event
+		return &event, nil
+	}
 	if len(this.cachedEvents) == 0 {
 		// End of logs
 		return nil, nil
 	}
 	if this.currentEventIndex+1 < len(this.cachedEvents) {
 		// We have enough cache to go by
+		if this.currentEventIndex >= 0 {
+			// The previous event has been consumed. We do not retain its Info, which may be large (row events),
+			// so as to reduce memory footprint of long scans.
+			this.cachedEvents[this.currentEventIndex].Info = ""
+		}
 		this.currentEventIndex++
-		event := &this.cachedEvents[this.currentEventIndex]
+		event := this.cachedEvents[this.currentEventIndex]
 		this.nextCoordinates = event.NextBinlogCoordinates()
-		return event, nil
+		this.lastEvent = &event
+		return &event, nil
 	} else {
 		// Cache exhausted; get next bulk of entries and return the next entry
 		var err error
@@ -107,22 +707,131 @@ func (this *BinlogEventCursor) NextEvent() (*BinlogEvent, error) {
 }
 
 // NextRealEvent returns the next event from binlog that is not meta/control event (these are start-of-binary-log,
-// rotate-binary-log etc.)
+// rotate-binary-log etc.), or, more generally, the next event satisfying the cursor's "is real event" predicate.
+// It also skips events ignored via PseudoGTIDMatchIgnorePatterns, as well as complete transactions consisting
+// of such events only. Since both cursors of a match apply the same filter, the two streams remain aligned.
 func (this *BinlogEventCursor) NextRealEvent() (*BinlogEvent, error) {
-	event, err := this.NextEvent()
-	if err != nil {
+	for {
+		event, err := this.NextEvent()
+		if err != nil {
+			return event, err
+		}
+		if event == nil {
+			return event, err
+		}
+		if !this.isRealEvent(*event) {
+			// A few entries (end-of-binlog followed by start-of-bin-log) are possible
+			continue
+		}
+		if this.isIgnoredEvent(event) {
+			continue
+		}
+		if isTransactionBeginEvent(event) && len(this.ignoreRegexps) > 0 {
+			ignored, err := this.skipIgnoredTransaction()
+			if err != nil {
+				return nil, err
+			}
+			if ignored {
+				continue
+			}
+		}
+		event.NormalizeInfo()
 		return event, err
 	}
-	if event == nil {
-		return event, err
+}
+
+// isIgnoredEvent checks whether given event matches any of PseudoGTIDMatchIgnorePatterns. Rows events carry no
+// table name; they are ignored when their table id has been mapped by an ignored Table_map event.
+func (this *BinlogEventCursor) isIgnoredEvent(event *BinlogEvent) bool {
+	if len(this.ignoreRegexps) == 0 {
+		return false
 	}
-	if _, found := skippedEventTypes[event.EventType]; found {
-		// Recursion will not be deep here. A few entries (end-of-binlog followed by start-of-bin-log) are possible,
-		// but we really don't expect a huge sequence of those.
-		return this.NextRealEvent()
+	if strings.HasSuffix(event.EventType, "_rows") && event.EventType != "Annotate_rows" {
+		if submatch := tableIdRegexp.FindStringSubmatch(event.Info); len(submatch) > 0 {
+			return this.ignoredTableIds[submatch[1]]
+		}
+		return false
 	}
-	event.NormalizeInfo()
-	return event, err
+	for _, ignoreRegexp := range this.ignoreRegexps {
+		if ignoreRegexp.MatchString(event.Info) {
+			if event.EventType == "Table_map" {
+				if submatch := tableIdRegexp.FindStringSubmatch(event.Info); len(submatch) > 0 {
+					this.ignoredTableIds[submatch[1]] = true
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// skipIgnoredTransaction is called just after reading a transaction's BEGIN event. It reads ahead: if the transaction
+// consists of ignored events only, it is consumed in full and true is returned. Otherwise the events read ahead are
+// kept pending, to be returned by following calls, and the cursor's state is restored.
+func (this *BinlogEventCursor) skipIgnoredTransaction() (bool, error) {
+	nextCoordinates := this.nextCoordinates
+	lastEvent := this.lastEvent
+	readAheadEvents := []BinlogEvent{}
+	hasIgnoredEvents := false
+	for {
+		event, err := this.NextEvent()
+		if err != nil {
+			return false, err
+		}
+		if event == nil {
+			break
+		}
+		readAheadEvents = append(readAheadEvents, *event)
+		if isTransactionEndEvent(event) {
+			if hasIgnoredEvents {
+				return true, nil
+			}
+			break
+		}
+		if !this.isRealEvent(*event) {
+			continue
+		}
+		if _, found := transactionContextEventTypes[event.EventType]; found {
+			continue
+		}
+		if this.isIgnoredEvent(event) {
+			hasIgnoredEvents = true
+			continue
+		}
+		// A real event; this transaction is not to be ignored
+		break
+	}
+	this.pendingEvents = append(readAheadEvents, this.pendingEvents...)
+	this.nextCoordinates = nextCoordinates
+	this.lastEvent = lastEvent
+	return false, nil
+}
+
+func isTransactionBeginEvent(event *BinlogEvent) bool {
+	return event.EventType == "Query" && event.Info == "BEGIN"
+}
+
+func isTransactionEndEvent(event *BinlogEvent) bool {
+	if event.EventType == "Xid" {
+		return true
+	}
+	return event.EventType == "Query" && (event.Info == "COMMIT" || event.Info == "ROLLBACK")
+}
+
+// unreadEvents returns given events, which must be the latest events read off the cursor, in order, back to the
+// cursor, such that they are read again by following calls.
+func (this *BinlogEventCursor) unreadEvents(events []BinlogEvent) {
+	if len(events) == 0 {
+		return
+	}
+	this.pendingEvents = append(append([]BinlogEvent{}, events...), this.pendingEvents...)
+	this.nextCoordinates = events[0].Coordinates
+}
+
+// LastEvent returns the last event read by the cursor, including meta/control events which are skipped by NextRealEvent.
+// As with NextEvent, this is a copy, unaffected by further reads. Returns nil if no event has been read.
+func (this *BinlogEventCursor) LastEvent() *BinlogEvent {
+	return this.lastEvent
 }
 
 // NextCoordinates return the binlog coordinates of the next entry as yet unprocessed by the cursor.
@@ -17,216 +17,1603 @@
 package inst
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"github.com/go-sql-driver/mysql"
 	"github.com/outbrain/golib/log"
 	"github.com/outbrain/golib/math"
 	"github.com/outbrain/golib/sqlutils"
 	"github.com/outbrain/orchestrator/config"
 	"github.com/outbrain/orchestrator/db"
 	"github.com/pmylund/go-cache"
-	"regexp"
+	"io"
+	"net"
+	"strings"
 	"time"
 )
 
 const binlogEventsChunkSize int = 1000000
 
+// pseudoGTIDSearchProgressRows is the interval, in events, at which Pseudo-GTID searches report progress
+const pseudoGTIDSearchProgressRows int64 = 10000
+
+// pseudoGTIDDiagnosisPeekEvents is the number of events examined by diagnoseMissingPseudoGTID
+const pseudoGTIDDiagnosisPeekEvents = 1000
+
+// pseudoGTIDIntervalSampleSize is the number of latest Pseudo-GTID entries sampled by DetectPseudoGTIDInterval
+const pseudoGTIDIntervalSampleSize = 10
+
+// pseudoGTIDWaitPollInterval is the interval at which WaitForPseudoGTIDBeyond polls for new entries
+const pseudoGTIDWaitPollInterval = time.Second
+
+// pseudoGTIDFreshBinlogMaxSize is the size up to which a sole binary log is considered freshly created, such that
+// GetLastPseudoGTIDEntryInInstance waits for a Pseudo-GTID entry to show up in it
+const pseudoGTIDFreshBinlogMaxSize int64 = 1024 * 1024
+
+// selfBinlogCoordinatesRetryInterval is the pause before re-reading binary logs which disagree with master status
+const selfBinlogCoordinatesRetryInterval = 100 * time.Millisecond
+
+// binlogMatchFetchRetryInterval is the pause before retrying a failed fetch of events mid-match
+const binlogMatchFetchRetryInterval = time.Second
+
 var instancePseudoGTIDEntryCache = cache.New(time.Duration(10)*time.Minute, time.Minute)
 
-func getInstancePseudoGTIDKey(instance *Instance, entry string) string {
-	return fmt.Sprintf("%s;%s", instance.Key.DisplayString, entry)
+// pseudoGTIDBucketCache holds, per instance and bucket (see PseudoGTIDCacheBucketPattern), the coordinates of the
+// earliest known entry in the bucket
+var pseudoGTIDBucketCache = cache.New(time.Duration(10)*time.Minute, time.Minute)
+
+// pseudoGTIDCacheWarmedInstances marks instances recently scanned by WarmPseudoGTIDCache
+var pseudoGTIDCacheWarmedInstances = cache.New(time.Duration(10)*time.Minute, time.Minute)
+
+// pseudoGTIDCacheWarmingChan prevents concurrent runs of WarmPseudoGTIDCache
+var pseudoGTIDCacheWarmingChan = make(chan bool, 1)
+
+// pseudoGTIDCacheVerifyingChan prevents concurrent runs of VerifyPseudoGTIDCache
+var pseudoGTIDCacheVerifyingChan = make(chan bool, 1)
+
+// pseudoGTIDTailProbes holds, per instance, the pseudoGTIDTailProbe state left by the last tail probe
+var pseudoGTIDTailProbes = cache.New(time.Duration(10)*time.Minute, time.Minute)
+
+// ErrSameInstance is returned when attempting to match an instance's binary logs against its own
+var ErrSameInstance = errors.New("Cannot match binlog coordinates of an instance against itself")
+
+// ErrAnchorNotFoundOnTarget is returned when a Pseudo-GTID entry is searched for and is simply not found (as opposed
+// to the search failing). In a match, this means the target does not (or no longer) have the entry: typically
+// the target is behind the instance, or has purged its binary logs.
+var ErrAnchorNotFoundOnTarget = errors.New("Pseudo-GTID anchor not found on target")
+
+// ErrPseudoGTIDNotFound is returned when no Pseudo-GTID entry is found within the newest binary logs of an
+// instance, as bounded by PseudoGTIDSearchMaxBinlogs. This typically means Pseudo-GTID injection has stopped.
+var ErrPseudoGTIDNotFound = errors.New("Pseudo-GTID entry not found in newest binary logs")
+
+// ErrNoRelayLogs is returned when searching the relay logs of an instance which has none, typically since it is
+// not a slave. This is distinct from a failed search.
+var ErrNoRelayLogs = errors.New("Instance has no relay logs; not a slave")
+
+// getBinlogScanCredentials returns the user & password with which to scan binary/relay logs on given instance
+func getBinlogScanCredentials(instanceKey *InstanceKey) (user string, password string) {
+	user, password = config.Config.MySQLTopologyUser, config.Config.MySQLTopologyPassword
+	if credentials, found := config.Config.BinlogScanCredentials[instanceKey.DisplayString()]; found {
+		user, password = credentials.User, credentials.Password
+	}
+	return user, password
+}
+
+// openTopologyForBinlogScan returns a DB instance used for scanning binary/relay logs on given instance.
+// Such scans may use per-instance credentials (see BinlogScanCredentials), falling back to the
+// common topology credentials. Being heavyweight operations, they may also use a longer connect timeout.
+func openTopologyForBinlogScan(instanceKey *InstanceKey) (*sql.DB, error) {
+	user, password := getBinlogScanCredentials(instanceKey)
+	connectTimeoutSeconds := config.Config.MySQLConnectTimeoutSeconds
+	if config.Config.BinlogConnectTimeoutSeconds > 0 {
+		connectTimeoutSeconds = config.Config.BinlogConnectTimeoutSeconds
+	}
+	return db.OpenTopologyAs(instanceKey.Hostname, instanceKey.Port, user, password, connectTimeoutSeconds)
+}
+
+func getInstancePseudoGTIDKey(instance *Instance, entry string) string {
+	return fmt.Sprintf("%s;%s", instance.Key.DisplayString(), entry)
+}
+
+// getPseudoGTIDBucket returns the bucket of given entry, as per PseudoGTIDCacheBucketPattern; an empty string when
+// the entry is not bucketed
+func getPseudoGTIDBucket(entryText string) string {
+	if config.Config.PseudoGTIDCacheBucketPattern == "" {
+		return ""
+	}
+	bucketRegexp, err := compilePattern(config.Config.PseudoGTIDCacheBucketPattern)
+	if err != nil {
+		log.Errore(err)
+		return ""
+	}
+	submatch := bucketRegexp.FindStringSubmatch(entryText)
+	if len(submatch) < 2 {
+		return ""
+	}
+	return submatch[1]
+}
+
+// cachePseudoGTIDEntryCoordinates caches the coordinates of given entry on given instance. A bucketed entry only
+// makes for its bucket's cached coordinates, and only if it precedes the bucket's known entries.
+func cachePseudoGTIDEntryCoordinates(instance *Instance, entryText string, coordinates *BinlogCoordinates) {
+	bucket := getPseudoGTIDBucket(entryText)
+	if bucket == "" {
+		instancePseudoGTIDEntryCache.Set(getInstancePseudoGTIDKey(instance, entryText), coordinates, 0)
+		return
+	}
+	bucketKey := getInstancePseudoGTIDKey(instance, bucket)
+	if cached, found := pseudoGTIDBucketCache.Get(bucketKey); found && cached.(*BinlogCoordinates).SmallerThan(coordinates) {
+		return
+	}
+	pseudoGTIDBucketCache.Set(bucketKey, coordinates, 0)
+}
+
+// searchPseudoGTIDEntryInBucket looks for given entry starting at the cached coordinates of its bucket, if any.
+// Entries are expected to follow their bucket's earliest known entry, typically in the same binary log; failing
+// that, the result is empty, and the caller resorts to a full search.
+func searchPseudoGTIDEntryInBucket(instance *Instance, entryText string) (*BinlogCoordinates, bool) {
+	bucket := getPseudoGTIDBucket(entryText)
+	if bucket == "" {
+		return nil, false
+	}
+	cached, found := pseudoGTIDBucketCache.Get(getInstancePseudoGTIDKey(instance, bucket))
+	if !found {
+		return nil, false
+	}
+	bucketCoordinates := cached.(*BinlogCoordinates)
+	log.Debugf("Searching for given pseudo gtid entry in %+v starting bucket %s at %+v", instance.Key, bucket, *bucketCoordinates)
+	resultCoordinates, err := searchPseudoGTIDEntryInBinlogFrom(&instance.Key, *bucketCoordinates, entryText, nil)
+	if resultCoordinates.LogPos == 0 || err != nil {
+		return nil, false
+	}
+	return &resultCoordinates, true
+}
+
+// parseInstancePseudoGTIDKey is the reverse of getInstancePseudoGTIDKey: it extracts the instance key and entry text
+// from a cache key
+func parseInstancePseudoGTIDKey(cacheKey string) (*InstanceKey, string, error) {
+	tokens := strings.SplitN(cacheKey, ";", 2)
+	if len(tokens) != 2 {
+		return nil, "", errors.New(fmt.Sprintf("Cannot parse pseudo GTID cache key: %s", cacheKey))
+	}
+	instanceKey, err := ParseInstanceKey(tokens[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return instanceKey, tokens[1], nil
+}
+
+// ReadBinaryLogFiles lists the binary logs of given instance along with their sizes, via SHOW BINARY LOGS
+func ReadBinaryLogFiles(instanceKey *InstanceKey) ([]BinaryLogFile, error) {
+	binaryLogFiles := []BinaryLogFile{}
+	db, err := openTopologyForBinlogScan(instanceKey)
+	if err != nil {
+		return binaryLogFiles, err
+	}
+	err = sqlutils.QueryRowsMap(db, "show binary logs", func(m sqlutils.RowMap) error {
+		binaryLogFiles = append(binaryLogFiles, BinaryLogFile{LogFile: m.GetString("Log_name"), Size: m.GetInt64("File_size")})
+		return nil
+	})
+	return binaryLogFiles, checkBinlogPrivilegesError(instanceKey, err)
+}
+
+// refreshBinaryLogs re-reads the list of binary logs of given instance, via SHOW BINARY LOGS
+func refreshBinaryLogs(instance *Instance) error {
+	_, err := refreshBinaryLogFiles(instance)
+	return err
+}
+
+// refreshBinaryLogFiles is refreshBinaryLogs, which further returns the binary logs along with their sizes
+func refreshBinaryLogFiles(instance *Instance) ([]BinaryLogFile, error) {
+	binaryLogFiles, err := ReadBinaryLogFiles(&instance.Key)
+	if err != nil {
+		return binaryLogFiles, err
+	}
+	binlogs := []string{}
+	for _, binaryLogFile := range binaryLogFiles {
+		binlogs = append(binlogs, binaryLogFile.LogFile)
+	}
+	instance.SetBinaryLogs(binlogs)
+	return binaryLogFiles, nil
+}
+
+// isFreshBinaryLogs returns true for the binary logs of a freshly started (or RESET MASTER) instance: none at all,
+// or a single, nearly empty one
+func isFreshBinaryLogs(binaryLogFiles []BinaryLogFile) bool {
+	if len(binaryLogFiles) == 0 {
+		return true
+	}
+	return len(binaryLogFiles) == 1 && binaryLogFiles[0].Size <= pseudoGTIDFreshBinlogMaxSize
+}
+
+// EstimatePseudoGTIDScanCost estimates how many bytes & events a worst case Pseudo-GTID scan of given instance's
+// binary logs would traverse. This is a rough estimate based on binary log sizes, intended for warning users
+// before they trigger a heavyweight operation.
+func EstimatePseudoGTIDScanCost(instance *Instance) (*BinlogScanCostEstimate, error) {
+	binaryLogFiles, err := ReadBinaryLogFiles(&instance.Key)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	return NewBinlogScanCostEstimate(&instance.Key, binaryLogFiles), nil
+}
+
+// BinlogLagBytes computes how many bytes of binary log given slave is behind given master: from the slave's executed
+// master coordinates up to the master's current position, as per SHOW MASTER STATUS. For write heavy workloads
+// this is a more telling metric than Seconds_Behind_Master.
+func BinlogLagBytes(master *Instance, slave *Instance) (int64, error) {
+	if !slave.IsSlaveOf(master) {
+		return 0, errors.New(fmt.Sprintf("%+v is not a slave of %+v", slave.Key, master.Key))
+	}
+	masterCoordinates, err := readMasterStatusCoordinates(&master.Key)
+	if err != nil {
+		return 0, log.Errore(err)
+	}
+	binaryLogFiles, err := ReadBinaryLogFiles(&master.Key)
+	if err != nil {
+		return 0, log.Errore(err)
+	}
+	return ComputeBinlogLagBytes(*masterCoordinates, slave.ExecBinlogCoordinates, binaryLogFiles)
+}
+
+// MatchStrategyRecommendation compares the cost of matching instance below other via Pseudo-GTID, i.e. the
+// number of events to scan, against the availability of GTID based matching, which requires no scanning at all.
+// This helps operators decide whether enabling GTID across the fleet is worthwhile.
+func MatchStrategyRecommendation(instance, other *Instance) (*MatchRecommendation, error) {
+	instanceCapabilities, err := ServerCapabilities(instance)
+	if err != nil {
+		return nil, err
+	}
+	otherCapabilities, err := ServerCapabilities(other)
+	if err != nil {
+		return nil, err
+	}
+	recommendation := &MatchRecommendation{Strategy: MatchStrategyNone}
+	if instanceCapabilities.Flavor == FlavorMariaDB || otherCapabilities.Flavor == FlavorMariaDB {
+		recommendation.GTIDAvailable = instanceCapabilities.Flavor == otherCapabilities.Flavor
+	} else {
+		recommendation.GTIDAvailable = instanceCapabilities.UsingOracleGTID() && otherCapabilities.UsingOracleGTID()
+	}
+	recommendation.PseudoGTIDAvailable = config.Config.PseudoGTIDPattern != "" && other.LogBinEnabled
+	if recommendation.PseudoGTIDAvailable {
+		// The search for the common entry may traverse all of other's binary logs
+		estimate, err := EstimatePseudoGTIDScanCost(other)
+		if err != nil {
+			return nil, err
+		}
+		recommendation.PseudoGTIDEstimatedEvents = estimate.EstimatedEvents
+	}
+
+	switch {
+	case recommendation.GTIDAvailable:
+		recommendation.Strategy = MatchStrategyGTID
+		recommendation.Rationale = fmt.Sprintf("GTID is enabled on both %+v and %+v; matching requires no binary log scanning", instance.Key, other.Key)
+		if recommendation.PseudoGTIDAvailable {
+			recommendation.Rationale = fmt.Sprintf("%s, whereas Pseudo-GTID matching would scan up to ~%d events", recommendation.Rationale, recommendation.PseudoGTIDEstimatedEvents)
+		}
+	case recommendation.PseudoGTIDAvailable:
+		recommendation.Strategy = MatchStrategyPseudoGTID
+		recommendation.Rationale = fmt.Sprintf("GTID is not enabled on both %+v and %+v; Pseudo-GTID matching would scan up to ~%d events", instance.Key, other.Key, recommendation.PseudoGTIDEstimatedEvents)
+	default:
+		recommendation.Rationale = fmt.Sprintf("Neither GTID nor Pseudo-GTID is available for matching %+v below %+v", instance.Key, other.Key)
+	}
+	return recommendation, nil
+}
+
+// GetBinlogCoordinatesAtOffset maps a cumulative byte offset across the instance's binary logs (as listed by
+// GetBinaryLogs, in ascending order) onto binlog coordinates, based on binary log sizes.
+func GetBinlogCoordinatesAtOffset(instance *Instance, offset int64) (*BinlogCoordinates, error) {
+	binaryLogFiles, err := ReadBinaryLogFiles(&instance.Key)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	binaryLogSizes := make(map[string]int64)
+	for _, binaryLogFile := range binaryLogFiles {
+		binaryLogSizes[binaryLogFile.LogFile] = binaryLogFile.Size
+	}
+	instanceBinaryLogFiles := []BinaryLogFile{}
+	for _, binlog := range SortBinaryLogs(instance.GetBinaryLogs()) {
+		size, found := binaryLogSizes[binlog]
+		if !found {
+			return nil, log.Errorf("Cannot find size of binlog %s on %+v", binlog, instance.Key)
+		}
+		instanceBinaryLogFiles = append(instanceBinaryLogFiles, BinaryLogFile{LogFile: binlog, Size: size})
+	}
+	return BinlogCoordinatesAtOffset(instanceBinaryLogFiles, offset)
+}
+
+// Try and find the last position of a pseudo GTID query entry in the given binary log.
+// Also return the full text of that entry.
+// maxCoordinates is the position beyond which we should not read. This is relevant when reading relay logs; in particular,
+// the last relay log. We must be careful not to scan for Pseudo-GTID entries past the position executed by the SQL thread.
+// maxCoordinates == nil means no limit.
+// With relay logs, maxCoordinates is further reduced by RelayLogScanSafetyMarginBytes, so that we do not
+// read (possibly half written) events trailing the limit.
+// With RelayLogScanStrictExecutedBound, an entry positioned exactly at maxCoordinates is not considered either:
+// maxCoordinates being the SQL thread's position, such an entry is yet to be applied.
+func getLastPseudoGTIDEntryInBinlog(instanceKey *InstanceKey, binlog string, binlogType BinlogType, maxCoordinates *BinlogCoordinates) (*BinlogCoordinates, string, error) {
+	binlogCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: binlogType}
+	if maxCoordinates != nil && binlogType == RelayLog && config.Config.RelayLogScanSafetyMarginBytes > 0 {
+		safeMaxCoordinates := *maxCoordinates
+		safeMaxCoordinates.LogPos -= config.Config.RelayLogScanSafetyMarginBytes
+		if safeMaxCoordinates.LogPos < 0 {
+			safeMaxCoordinates.LogPos = 0
+		}
+		maxCoordinates = &safeMaxCoordinates
+	}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entryText := ""
+	var lastEventCoordinates *BinlogCoordinates
+	scanRecord, err := scanBinlogEventsOfTypes(instanceKey, binlogCoordinates, pseudoGTIDCandidateEventTypes(), func(event *BinlogEvent) error {
+		lastEventCoordinates = &BinlogCoordinates{LogFile: binlog, LogPos: event.Coordinates.LogPos}
+		if IsPseudoGTIDCandidate(event) && pseudoGTIDRegexp.MatchString(event.Info) {
+			if maxCoordinates != nil {
+				entryCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: event.Coordinates.LogPos}
+				if maxCoordinates.SmallerThan(&entryCoordinates) {
+					// past the limitation
+					return errBinlogScanTerminated
+				}
+				if config.Config.RelayLogScanStrictExecutedBound && maxCoordinates.Equals(&entryCoordinates) {
+					// at the limitation: not yet executed
+					return errBinlogScanTerminated
+				}
+			}
+			binlogCoordinates.LogPos = event.Coordinates.LogPos
+			entryText = event.Info
+			// Found a match. But we keep searching: we're interested in the LAST entry, and, alas,
+			// we can only search in ASCENDING order...
+		}
+		return nil
+	})
+	emitBinlogScanRecord(scanRecord, "GetLastPseudoGTIDEntry", binlogCoordinates.LogPos != 0, err)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxCoordinates != nil && maxCoordinates.LogFile == binlog && (lastEventCoordinates == nil || lastEventCoordinates.SmallerThan(maxCoordinates)) {
+		// The log ended before reaching the bound; e.g. the recorded position refers to events not (yet) visible to us.
+		// Not an error, but the bound could not be verified.
+		log.Debugf("Scan of %+v on %+v ended at %+v, without reaching bound %+v", binlog, *instanceKey, lastEventCoordinates, *maxCoordinates)
+	}
+
+	// Not found? return nil. an error is reserved to SQL problems.
+	if binlogCoordinates.LogPos == 0 {
+		return nil, "", nil
+	}
+	return &binlogCoordinates, entryText, err
+}
+
+func GetLastPseudoGTIDEntryInInstance(instance *Instance) (*BinlogCoordinates, string, error) {
+	// Look for last GTID in instance. We iterate newest-first, and do not take the order of binary logs for granted:
+	instanceBinlogs := SortBinaryLogs(instance.GetBinaryLogs())
+
+	// A freshly started (or RESET MASTER) instance may have no binary logs, or a single nearly empty one, yet to see
+	// a Pseudo-GTID injection. We give it a few moments, as per PseudoGTIDFreshBinlogsWaitSeconds. A long lived
+	// instance with a single, large binary log is not waited for.
+	for waited := uint(0); len(instanceBinlogs) <= 1 && waited < config.Config.PseudoGTIDFreshBinlogsWaitSeconds; waited++ {
+		binaryLogFiles, err := refreshBinaryLogFiles(instance)
+		if err != nil {
+			log.Errore(err)
+			break
+		}
+		instanceBinlogs = SortBinaryLogs(instance.GetBinaryLogs())
+		if !isFreshBinaryLogs(binaryLogFiles) {
+			break
+		}
+		if len(instanceBinlogs) == 1 {
+			resultCoordinates, entryInfo, err := getLastPseudoGTIDEntryInBinlog(&instance.Key, instanceBinlogs[0], BinaryLog, nil)
+			if err != nil {
+				return nil, "", err
+			}
+			if resultCoordinates != nil {
+				log.Debugf("Found pseudo gtid entry in %+v: %+v", instance.Key, resultCoordinates)
+				return resultCoordinates, entryInfo, err
+			}
+		}
+		log.Debugf("No pseudo gtid entry yet in fresh binary logs of %+v; waiting", instance.Key)
+		time.Sleep(time.Second)
+	}
+
+	for i := len(instanceBinlogs) - 1; i >= 0; i-- {
+		if binlogsSearched := uint(len(instanceBinlogs) - 1 - i); config.Config.PseudoGTIDSearchMaxBinlogs > 0 && binlogsSearched >= config.Config.PseudoGTIDSearchMaxBinlogs {
+			log.Warningf("Cannot find pseudo GTID entry in %d newest binlogs of %+v; giving up as per PseudoGTIDSearchMaxBinlogs. Is Pseudo-GTID being injected?", binlogsSearched, instance.Key)
+			return nil, "", ErrPseudoGTIDNotFound
+		}
+		log.Debugf("Searching for latest pseudo gtid entry in binlog %+v of %+v", instanceBinlogs[i], instance.Key)
+		resultCoordinates, entryInfo, err := getLastPseudoGTIDEntryInBinlog(&instance.Key, instanceBinlogs[i], BinaryLog, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		if resultCoordinates != nil {
+			log.Debugf("Found pseudo gtid entry in %+v: %+v", instance.Key, resultCoordinates)
+			return resultCoordinates, entryInfo, err
+		}
+	}
+	return nil, "", log.Errorf("Cannot find pseudo GTID entry in binlogs of %+v; examined %d binlogs: %s%s", instance.Key, len(instanceBinlogs), strings.Join(instanceBinlogs, ", "), diagnoseMissingPseudoGTID(instance))
+}
+
+// GetFirstPseudoGTIDEntryInInstance finds the oldest Pseudo-GTID entry available in the binary logs of given
+// instance. This marks the beginning of the instance's Pseudo-GTID history: older entries have been purged.
+func GetFirstPseudoGTIDEntryInInstance(instance *Instance) (*BinlogCoordinates, string, error) {
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return nil, "", err
+	}
+	instanceBinlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	for _, binlog := range instanceBinlogs {
+		log.Debugf("Searching for first pseudo gtid entry in binlog %+v of %+v", binlog, instance.Key)
+		var resultCoordinates *BinlogCoordinates
+		entryText := ""
+		startingCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}
+		scanRecord, err := scanBinlogEventsOfTypes(&instance.Key, startingCoordinates, pseudoGTIDCandidateEventTypes(), func(event *BinlogEvent) error {
+			if IsPseudoGTIDCandidate(event) && pseudoGTIDRegexp.MatchString(event.Info) {
+				resultCoordinates = &BinlogCoordinates{LogFile: binlog, LogPos: event.Coordinates.LogPos, Type: BinaryLog}
+				entryText = event.Info
+				return errBinlogScanTerminated
+			}
+			return nil
+		})
+		emitBinlogScanRecord(scanRecord, "GetFirstPseudoGTIDEntry", resultCoordinates != nil, err)
+		if err != nil {
+			return nil, "", err
+		}
+		if resultCoordinates != nil {
+			log.Debugf("Found first pseudo gtid entry in %+v: %+v", instance.Key, *resultCoordinates)
+			return resultCoordinates, entryText, nil
+		}
+	}
+	return nil, "", log.Errorf("Cannot find pseudo GTID entry in binlogs of %+v; examined %d binlogs", instance.Key, len(instanceBinlogs))
+}
+
+// WaitForPseudoGTIDBeyond polls given instance until its latest Pseudo-GTID entry is positioned beyond given
+// coordinates, or until timeout elapses. This ensures Pseudo-GTID injection has progressed past a known point
+// before proceeding with a dependent operation. It returns the entry found beyond the coordinates.
+func WaitForPseudoGTIDBeyond(instance *Instance, coordinates BinlogCoordinates, timeout time.Duration) (*BinlogCoordinates, string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		// The entry may well be in a binary log rotated since the instance was read
+		if err := refreshBinaryLogs(instance); err != nil {
+			return nil, "", log.Errore(err)
+		}
+		entryCoordinates, entryText, err := GetLastPseudoGTIDEntryInInstance(instance)
+		if err == nil && coordinates.SmallerThan(entryCoordinates) {
+			return entryCoordinates, entryText, nil
+		}
+		if time.Now().Add(pseudoGTIDWaitPollInterval).After(deadline) {
+			return nil, "", log.Errorf("Timed out waiting for pseudo gtid entry beyond %+v on %+v", coordinates, instance.Key)
+		}
+		time.Sleep(pseudoGTIDWaitPollInterval)
+	}
+}
+
+// HasPseudoGTID checks whether the newest binary log of given instance holds any Pseudo-GTID entry. This is a
+// cheap check (a single binary log, ending at the first entry found) of whether Pseudo-GTID matching is viable
+// for the instance. A false result is not final: the newest binary log may have just been rotated.
+func HasPseudoGTID(instance *Instance) (bool, error) {
+	if config.Config.PseudoGTIDPattern == "" {
+		return false, nil
+	}
+	binlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	if len(binlogs) == 0 {
+		return false, nil
+	}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	startingCoordinates := BinlogCoordinates{LogFile: binlogs[len(binlogs)-1], LogPos: 0, Type: BinaryLog}
+	scanRecord, err := scanBinlogEvents(&instance.Key, startingCoordinates, func(event *BinlogEvent) error {
+		if IsPseudoGTIDCandidate(event) && pseudoGTIDRegexp.MatchString(event.Info) {
+			found = true
+			return errBinlogScanTerminated
+		}
+		return nil
+	})
+	emitBinlogScanRecord(scanRecord, "HasPseudoGTID", found, err)
+	return found, err
+}
+
+// pseudoGTIDTailProbe is the progress of tail probes through an instance's newest binary log: the position from
+// which the next probe reads, and the last Pseudo-GTID entry read so far, if any.
+type pseudoGTIDTailProbe struct {
+	resumeCoordinates BinlogCoordinates
+	entryCoordinates  *BinlogCoordinates
+	entryText         string
+}
+
+// ProbeLastPseudoGTIDEntryInTail is a fast alternative to GetLastPseudoGTIDEntryInInstance, for health checks which
+// only need to confirm a recent Pseudo-GTID entry exists. It reads a single chunk of the newest binary log, and
+// returns the last entry in that log; nil coordinates mean no entry was found in the tail.
+// Since events can only be read forward from a known event position, a probe reads on from where the previous probe
+// of the instance stopped, or from the beginning of the newest binary log after a rotation. An entry is only returned
+// once the probe has read through to the end of the log, as per a short chunk or the instance's
+// SelfBinlogCoordinates. Until then, which on a binary log larger than a chunk may take several probes, the probe
+// reports no entry rather than an entry from the head of the log. A probe finding no newer entry returns the
+// previous probe's entry; liveness checks should verify the returned coordinates advance between probes.
+func ProbeLastPseudoGTIDEntryInTail(instance *Instance) (*BinlogCoordinates, string, error) {
+	binlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	if len(binlogs) == 0 {
+		return nil, "", nil
+	}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return nil, "", err
+	}
+	probe := pseudoGTIDTailProbe{resumeCoordinates: BinlogCoordinates{LogFile: binlogs[len(binlogs)-1], LogPos: 0, Type: BinaryLog}}
+	if previousProbe, found := pseudoGTIDTailProbes.Get(instance.Key.DisplayString()); found {
+		if previousProbe := previousProbe.(pseudoGTIDTailProbe); previousProbe.resumeCoordinates.LogFile == probe.resumeCoordinates.LogFile {
+			probe = previousProbe
+		}
+	}
+	events, err := readBinlogEventsChunk(&instance.Key, probe.resumeCoordinates, binlogEventsChunkSize)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if IsPseudoGTIDCandidate(&events[i]) && pseudoGTIDRegexp.MatchString(events[i].Info) {
+			entryCoordinates := events[i].Coordinates
+			probe.entryCoordinates = &entryCoordinates
+			probe.entryText = events[i].Info
+			break
+		}
+	}
+	reachedTail := len(events) < binlogEventsChunkSize
+	if len(events) > 0 {
+		lastEvent := events[len(events)-1]
+		probe.resumeCoordinates = lastEvent.Coordinates
+		if lastEvent.Coordinates.LogFile == instance.SelfBinlogCoordinates.LogFile && lastEvent.NextEventPos >= instance.SelfBinlogCoordinates.LogPos {
+			reachedTail = true
+		}
+	}
+	pseudoGTIDTailProbes.Set(instance.Key.DisplayString(), probe, 0)
+
+	if !reachedTail {
+		log.Debugf("Tail probe of %+v has not reached the tail of %s yet; read up to %+v", instance.Key, probe.resumeCoordinates.LogFile, probe.resumeCoordinates)
+		return nil, "", nil
+	}
+	if probe.entryCoordinates == nil {
+		log.Debugf("No pseudo gtid entry in tail of %+v, %s", instance.Key, probe.resumeCoordinates.LogFile)
+		return nil, "", nil
+	}
+	return probe.entryCoordinates, probe.entryText, nil
+}
+
+// diagnoseMissingPseudoGTID is called when Pseudo-GTID entries are not found where expected on given instance.
+// It explains the common case of a row-based, GTID enabled instance whose binary logs hold no Pseudo-GTID
+// Query events (e.g. the injected statement was logged in row format), returning a hint for the error message.
+// Otherwise, it returns an empty string. Only the head of the newest binary log is examined.
+func diagnoseMissingPseudoGTID(instance *Instance) string {
+	if instance.Binlog_format != "ROW" {
+		return ""
+	}
+	binlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	if len(binlogs) == 0 {
+		return ""
+	}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return ""
+	}
+	events, err := PeekBinlogEvents(&instance.Key, BinlogCoordinates{LogFile: binlogs[len(binlogs)-1], LogPos: 0, Type: BinaryLog}, pseudoGTIDDiagnosisPeekEvents)
+	if err != nil {
+		return ""
+	}
+	hasGTIDEvents := false
+	for _, event := range events {
+		if event.EventType == "Query" && pseudoGTIDRegexp.MatchString(event.Info) {
+			return ""
+		}
+		if event.EventType == "Gtid" {
+			hasGTIDEvents = true
+		}
+	}
+	if !hasGTIDEvents {
+		return ""
+	}
+	return ". Binary logs are row-based and GTID enabled, with no Pseudo-GTID Query events; consider GTID based matching instead"
+}
+
+// GetLastPseudoGTIDEntryInRelayLogs finds the latest Pseudo-GTID entry in the relay logs of given instance, up to
+// given relay log coordinates. ErrNoRelayLogs is returned for an instance which is not a slave.
+func GetLastPseudoGTIDEntryInRelayLogs(instance *Instance, recordedInstanceRelayLogCoordinates BinlogCoordinates) (*BinlogCoordinates, string, error) {
+	return GetLastPseudoGTIDEntryInRelayLogsSince(instance, recordedInstanceRelayLogCoordinates, "")
+}
+
+// GetLastPseudoGTIDEntryInRelayLogsSince is as GetLastPseudoGTIDEntryInRelayLogs, but does not walk back beyond
+// given floor relay log (which is itself searched). This is useful when the entry is known to be newer than some
+// relay log, e.g. one in which a previous match succeeded. An empty floor means no bound.
+func GetLastPseudoGTIDEntryInRelayLogsSince(instance *Instance, recordedInstanceRelayLogCoordinates BinlogCoordinates, floorRelayLogFile string) (*BinlogCoordinates, string, error) {
+	// Look for last GTID in relay logs:
+	// Since MySQL does not provide with a SHOW RELAY LOGS command, we heuristically srtart from current
+	// relay log (indiciated by Relay_log_file) and walk backwards.
+	// Eventually we will hit a relay log name which does not exist.
+	// The search is bound by the SQL thread position (Relay_log_file:Relay_log_pos). The IO thread may well be ahead,
+	// having written further events -- possibly further relay logs -- which are not yet applied. On semi-sync
+	// replicas these may even include events not yet acknowledged. We never look beyond the SQL thread position,
+	// hence never match on un-applied relay log events.
+	if !instance.IsSlave() || recordedInstanceRelayLogCoordinates.LogFile == "" {
+		log.Debugf("%+v has no relay logs to search for pseudo gtid entry", instance.Key)
+		return nil, "", ErrNoRelayLogs
+	}
+	if !instance.ReadBinlogCoordinates.Equals(&instance.ExecBinlogCoordinates) {
+		log.Debugf("%+v has un-applied relay log events: read master coordinates %+v, executed master coordinates %+v. These are ignored", instance.Key, instance.ReadBinlogCoordinates, instance.ExecBinlogCoordinates)
+	}
+	currentRelayLog := recordedInstanceRelayLogCoordinates
+	var err error = nil
+	for err == nil {
+		log.Debugf("Searching for latest pseudo gtid entry in relaylog %+v of %+v, up to pos %+v", currentRelayLog.LogFile, instance.Key, recordedInstanceRelayLogCoordinates)
+		if resultCoordinates, entryInfo, err := getLastPseudoGTIDEntryInBinlog(&instance.Key, currentRelayLog.LogFile, RelayLog, &recordedInstanceRelayLogCoordinates); err != nil {
+			return nil, "", err
+		} else if resultCoordinates != nil {
+			log.Debugf("Found pseudo gtid entry in %+v: %+v", instance.Key, resultCoordinates)
+			return resultCoordinates, entryInfo, err
+		}
+		if floorRelayLogFile != "" && !binlogFileNameSmallerThan(floorRelayLogFile, currentRelayLog.LogFile) {
+			return nil, "", log.Errorf("Cannot find pseudo GTID entry in relay logs of %+v since %+v", instance.Key, floorRelayLogFile)
+		}
+		currentRelayLog, err = currentRelayLog.PreviousFileCoordinates()
+	}
+	return nil, "", log.Errorf("Cannot find pseudo GTID entry in relay logs of %+v", instance.Key)
+}
+
+// RelayLogFullyApplied checks whether the SQL thread of given instance has applied all relay log events written by
+// the IO thread, as per the instance's recorded replication coordinates. Otherwise relay log based matching is
+// bound at the applied position (see GetLastPseudoGTIDEntryInRelayLogs), and callers may prefer to wait for the
+// SQL thread to catch up before matching.
+func RelayLogFullyApplied(instance *Instance) (bool, error) {
+	if !instance.IsSlave() {
+		return false, errors.New(fmt.Sprintf("instance is not a slave: %+v", instance.Key))
+	}
+	if !instance.ReadBinlogCoordinates.Equals(&instance.ExecBinlogCoordinates) {
+		// The IO thread has read master events which the SQL thread has yet to execute
+		return false, nil
+	}
+	// Relay_log_pos is where the SQL thread's next event lies. A fully applied relay log has no event there;
+	// not even a Rotate event pointing to the next relay log.
+	events, err := PeekBinlogEvents(&instance.Key, instance.RelaylogCoordinates, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(events) > 0 {
+		log.Debugf("%+v has un-applied relay log events at %+v", instance.Key, instance.RelaylogCoordinates)
+		return false, nil
+	}
+	return true, nil
+}
+
+// RelayToMasterCoordinate translates relay log coordinates of given slave onto the master's binary log coordinates
+// following the event found there. A relay log event's End_log_pos is that of the event in the master's binary log;
+// the master's binary log file is the one last rotated to (via a Rotate event) earlier in the relay log.
+func RelayToMasterCoordinate(instance *Instance, relayCoordinates BinlogCoordinates) (*BinlogCoordinates, error) {
+	if relayCoordinates.Type != RelayLog {
+		return nil, log.Errorf("RelayToMasterCoordinate: %+v are not relay log coordinates", relayCoordinates)
+	}
+	var masterCoordinates *BinlogCoordinates
+	masterLogFile := ""
+	startingCoordinates := BinlogCoordinates{LogFile: relayCoordinates.LogFile, LogPos: 0, Type: RelayLog}
+	scanRecord, err := scanBinlogEvents(&instance.Key, startingCoordinates, func(event *BinlogEvent) error {
+		if relayCoordinates.SmallerThan(&event.Coordinates) {
+			return errors.New(fmt.Sprintf("No relay log event at %+v on %+v", relayCoordinates, instance.Key))
+		}
+		if event.IsRotateToMaster() {
+			masterLogFile = event.RotateCoordinates().LogFile
+		}
+		if !event.Coordinates.Equals(&relayCoordinates) {
+			return nil
+		}
+		if event.IsRotateToMaster() {
+			masterCoordinates = event.RotateCoordinates()
+			return errBinlogScanTerminated
+		}
+		if masterLogFile == "" || event.NextEventPos == 0 {
+			// e.g. the slave's own Format_desc event, not originating on the master
+			return errors.New(fmt.Sprintf("Relay log event at %+v on %+v has no master binary log position", relayCoordinates, instance.Key))
+		}
+		masterCoordinates = &BinlogCoordinates{LogFile: masterLogFile, LogPos: event.NextEventPos, Type: BinaryLog}
+		return errBinlogScanTerminated
+	})
+	emitBinlogScanRecord(scanRecord, "RelayToMasterCoordinate", masterCoordinates != nil, err)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	if masterCoordinates == nil {
+		return nil, log.Errorf("No relay log event at %+v on %+v", relayCoordinates, instance.Key)
+	}
+	return masterCoordinates, nil
+}
+
+// GetPreviousPseudoGTIDEntry finds the Pseudo-GTID entry preceding the one at given coordinates, in the instance's
+// binary logs or relay logs (as per the coordinates' type). Repeated calls yield successively older entries.
+func GetPreviousPseudoGTIDEntry(instance *Instance, entryCoordinates BinlogCoordinates) (*BinlogCoordinates, string, error) {
+	maxCoordinates := entryCoordinates
+	maxCoordinates.LogPos--
+	return FindNearestPseudoGTIDBeforeCoordinates(instance, maxCoordinates)
+}
+
+// FindNearestPseudoGTIDBeforeCoordinates finds the last Pseudo-GTID entry positioned at or before given coordinates,
+// in the instance's binary logs or relay logs (as per the coordinates' type). Unlike GetLastPseudoGTIDEntryInInstance,
+// the scan is bounded by the given coordinates rather than by the end of the logs.
+// With relay logs, the bound is subject to the same restrictions as GetLastPseudoGTIDEntryInRelayLogs.
+func FindNearestPseudoGTIDBeforeCoordinates(instance *Instance, coordinates BinlogCoordinates) (*BinlogCoordinates, string, error) {
+	maxCoordinates := coordinates
+	if coordinates.Type == RelayLog {
+		return GetLastPseudoGTIDEntryInRelayLogs(instance, maxCoordinates)
+	}
+	instanceBinlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	for i := len(instanceBinlogs) - 1; i >= 0; i-- {
+		if maxCoordinates.SmallerThan(&BinlogCoordinates{LogFile: instanceBinlogs[i], LogPos: 0}) {
+			// Newer than given coordinates
+			continue
+		}
+		log.Debugf("Searching for pseudo gtid entry at or before %+v in binlog %+v of %+v", coordinates, instanceBinlogs[i], instance.Key)
+		resultCoordinates, entryInfo, err := getLastPseudoGTIDEntryInBinlog(&instance.Key, instanceBinlogs[i], BinaryLog, &maxCoordinates)
+		if err != nil {
+			return nil, "", err
+		}
+		if resultCoordinates != nil {
+			log.Debugf("Found pseudo gtid entry in %+v: %+v", instance.Key, resultCoordinates)
+			return resultCoordinates, entryInfo, err
+		}
+	}
+	return nil, "", log.Errorf("Cannot find pseudo GTID entry at or before %+v in binlogs of %+v", coordinates, instance.Key)
+}
+
+// DetectPseudoGTIDClockSkew returns the difference between the instance's current time and the timestamp embedded
+// in its last Pseudo-GTID entry. A large difference indicates clock skew, or that Pseudo-GTID injection has stalled.
+func DetectPseudoGTIDClockSkew(instance *Instance) (time.Duration, error) {
+	_, entryText, err := GetLastPseudoGTIDEntryInInstance(instance)
+	if err != nil {
+		return 0, err
+	}
+	entryTime, err := ParsePseudoGTIDTimestamp(entryText)
+	if err != nil {
+		return 0, log.Errore(err)
+	}
+	var serverUnixTimestamp int64
+	if err := ScanInstanceRow(&instance.Key, "select unix_timestamp()", &serverUnixTimestamp); err != nil {
+		return 0, log.Errore(err)
+	}
+	return time.Unix(serverUnixTimestamp, 0).Sub(entryTime), nil
+}
+
+// Enumeration functions below (ListPseudoGTIDEntries...) follow a "partial result + error" convention: upon error,
+// they return the entries found up to the point of failure along with the error, rather than discarding them.
+// Callers should therefore not assume an empty result upon error.
+
+// ListPseudoGTIDEntriesInBinlog returns all Pseudo-GTID entries found in the given binary/relay log, in ascending order.
+// Upon error, entries found thus far are returned along with the error.
+func ListPseudoGTIDEntriesInBinlog(instanceKey *InstanceKey, binlog string, binlogType BinlogType) ([]PseudoGTIDEntry, error) {
+	entries := []PseudoGTIDEntry{}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return entries, err
+	}
+
+	startingCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: binlogType}
+	scanRecord, err := scanBinlogEvents(instanceKey, startingCoordinates, func(event *BinlogEvent) error {
+		if IsPseudoGTIDCandidate(event) && pseudoGTIDRegexp.MatchString(event.Info) {
+			entry := PseudoGTIDEntry{
+				Coordinates: BinlogCoordinates{LogFile: binlog, LogPos: event.Coordinates.LogPos, Type: binlogType},
+				Text:        event.Info,
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	emitBinlogScanRecord(scanRecord, "ListPseudoGTIDEntries", len(entries) > 0, err)
+	return entries, err
+}
+
+// ListPseudoGTIDEntriesInInstance returns all Pseudo-GTID entries found in the binary logs of given instance,
+// in ascending order. Upon error (e.g. a binary log purged while scanning), entries found thus far are
+// returned along with the error.
+func ListPseudoGTIDEntriesInInstance(instance *Instance) ([]PseudoGTIDEntry, error) {
+	entries := []PseudoGTIDEntry{}
+	for _, binlog := range instance.GetBinaryLogs() {
+		log.Debugf("Listing pseudo gtid entries in binlog %+v of %+v", binlog, instance.Key)
+		binlogEntries, err := ListPseudoGTIDEntriesInBinlog(&instance.Key, binlog, BinaryLog)
+		entries = append(entries, binlogEntries...)
+		if err != nil {
+			return entries, log.Errore(err)
+		}
+	}
+	return entries, nil
+}
+
+// DetectPseudoGTIDInterval samples the latest (up to pseudoGTIDIntervalSampleSize) Pseudo-GTID entries of given
+// instance and reports the typical interval between them, by position and, where entries embed a timestamp
+// (see PseudoGTIDTimestampPattern), by time.
+func DetectPseudoGTIDInterval(instance *Instance) (*PseudoGTIDInterval, error) {
+	entries, err := ListPseudoGTIDEntriesInInstance(instance)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) < 2 {
+		return nil, log.Errorf("Cannot detect pseudo gtid interval on %+v: found %d entries", instance.Key, len(entries))
+	}
+	if len(entries) > pseudoGTIDIntervalSampleSize {
+		entries = entries[len(entries)-pseudoGTIDIntervalSampleSize:]
+	}
+	return NewPseudoGTIDInterval(entries), nil
+}
+
+// Given a binlog entry text (query), search it in the given binary log of a given instance
+func SearchPseudoGTIDEntryInBinlog(instanceKey *InstanceKey, binlog string, entryText string) (BinlogCoordinates, error) {
+	return SearchPseudoGTIDEntryInBinlogWithProgress(instanceKey, binlog, entryText, nil)
+}
+
+// SearchPseudoGTIDEntryInBinlogWithProgress is SearchPseudoGTIDEntryInBinlog, which further reports the number of
+// events scanned so far to onProgress (when non-nil), every pseudoGTIDSearchProgressRows events and upon completion.
+func SearchPseudoGTIDEntryInBinlogWithProgress(instanceKey *InstanceKey, binlog string, entryText string, onProgress func(rowsScanned int64)) (BinlogCoordinates, error) {
+	return searchPseudoGTIDEntryInBinlogFrom(instanceKey, BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}, entryText, onProgress)
+}
+
+// searchPseudoGTIDEntryInBinlogFrom searches for given entry text in a binary log, starting at given coordinates,
+// which must be those of an event (or have LogPos 0). onProgress, if non-nil, is called as per SearchPseudoGTIDEntryInBinlogWithProgress.
+func searchPseudoGTIDEntryInBinlogFrom(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, entryText string, onProgress func(rowsScanned int64)) (BinlogCoordinates, error) {
+	binlog := startingCoordinates.LogFile
+	binlogCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: startingCoordinates.Type}
+	var rowsScanned int64
+	if onProgress != nil {
+		defer func() { onProgress(rowsScanned) }()
+	}
+	scanRecord, err := scanBinlogEvents(instanceKey, startingCoordinates, func(event *BinlogEvent) error {
+		rowsScanned++
+		if onProgress != nil && rowsScanned%pseudoGTIDSearchProgressRows == 0 {
+			onProgress(rowsScanned)
+		}
+		if IsPseudoGTIDCandidate(event) && EventInfoEquals(event.Info, entryText) {
+			// found it!
+			binlogCoordinates.LogPos = event.Coordinates.LogPos
+			return errBinlogScanTerminated
+		}
+		return nil
+	})
+	emitBinlogScanRecord(scanRecord, "SearchPseudoGTIDEntry", binlogCoordinates.LogPos != 0, err)
+	if err != nil {
+		return binlogCoordinates, err
+	}
+
+	if binlogCoordinates.LogPos == 0 {
+		log.Debugf("Cannot match pseudo GTID entry in binlog '%s' of %+v", binlog, *instanceKey)
+		return binlogCoordinates, ErrAnchorNotFoundOnTarget
+	}
+	return binlogCoordinates, err
+}
+
+// SearchAllPseudoGTIDEntryOccurrencesInBinlog returns the coordinates of all occurrences of given entry text in
+// the given binary log, in ascending order. Pseudo-GTID entries are expected to be unique; more than one occurrence
+// indicates a Pseudo-GTID generator not producing unique values.
+func SearchAllPseudoGTIDEntryOccurrencesInBinlog(instanceKey *InstanceKey, binlog string, entryText string) ([]BinlogCoordinates, error) {
+	occurrences := []BinlogCoordinates{}
+	startingCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}
+	scanRecord, err := scanBinlogEvents(instanceKey, startingCoordinates, func(event *BinlogEvent) error {
+		if IsPseudoGTIDCandidate(event) && EventInfoEquals(event.Info, entryText) {
+			occurrences = append(occurrences, BinlogCoordinates{LogFile: binlog, LogPos: event.Coordinates.LogPos, Type: BinaryLog})
+		}
+		return nil
+	})
+	emitBinlogScanRecord(scanRecord, "SearchAllPseudoGTIDEntryOccurrences", len(occurrences) > 0, err)
+	return occurrences, err
+}
+
+// SearchPseudoGTIDEntryInInstanceWithHint is similar to SearchPseudoGTIDEntryInInstance, but first looks for the entry
+// in the binary log indicated by hintCoordinates, starting at the hinted position, which must be that of an event
+// at or before the entry (e.g. coordinates of a previously matched entry). Should the entry not be found there,
+// it falls back to a full search.
+func SearchPseudoGTIDEntryInInstanceWithHint(instance *Instance, entryText string, hintCoordinates *BinlogCoordinates) (*BinlogCoordinates, error) {
+	if coords, found := getCachedPseudoGTIDEntryCoordinates(instance, entryText); found {
+		return coords, nil
+	}
+	if hintCoordinates != nil {
+		log.Debugf("Searching for given pseudo gtid entry in %+v starting %+v", instance.Key, *hintCoordinates)
+		resultCoordinates, err := searchPseudoGTIDEntryInBinlogFrom(&instance.Key, *hintCoordinates, entryText, nil)
+		if resultCoordinates.LogPos != 0 && err == nil {
+			log.Debugf("Matched entry in %+v: %+v", instance.Key, resultCoordinates)
+			cachePseudoGTIDEntryCoordinates(instance, entryText, &resultCoordinates)
+			return &resultCoordinates, nil
+		}
+		log.Debugf("Pseudo gtid entry not found in %+v starting %+v; falling back to full search", instance.Key, *hintCoordinates)
+	}
+	return SearchPseudoGTIDEntryInInstance(instance, entryText)
+}
+
+func SearchPseudoGTIDEntryInInstance(instance *Instance, entryText string) (*BinlogCoordinates, error) {
+	return SearchPseudoGTIDEntryInInstanceWithProgress(instance, entryText, nil)
+}
+
+// SearchPseudoGTIDEntryInCluster searches for given Pseudo-GTID entry in the binary logs of given instances,
+// concurrently (within the topology concurrency limit), tracing how far the entry has propagated.
+// The result maps each instance onto the coordinates of the entry, or onto nil where not found.
+// Should any search fail for reasons other than the entry not being found, the error is returned along with
+// the (partial) result.
+func SearchPseudoGTIDEntryInCluster(instances [](*Instance), entryText string) (map[InstanceKey]*BinlogCoordinates, error) {
+	type instanceSearchResult struct {
+		instanceKey InstanceKey
+		coordinates *BinlogCoordinates
+		err         error
+	}
+	results := make(chan instanceSearchResult)
+	for _, instance := range instances {
+		instance := instance
+		go func() {
+			result := instanceSearchResult{instanceKey: instance.Key}
+			ExecuteOnTopology(func() {
+				result.coordinates, result.err = SearchPseudoGTIDEntryInInstance(instance, entryText)
+			})
+			results <- result
+		}()
+	}
+	entryCoordinates := make(map[InstanceKey]*BinlogCoordinates)
+	var searchErr error
+	for _ = range instances {
+		result := <-results
+		entryCoordinates[result.instanceKey] = result.coordinates
+		if result.err != nil && result.err != ErrAnchorNotFoundOnTarget {
+			searchErr = result.err
+		}
+	}
+	return entryCoordinates, searchErr
+}
+
+// SearchPseudoGTIDEntryInInstanceSince searches for given Pseudo-GTID entry in the binary logs of given instance,
+// from given coordinates onwards, rather than throughout the instance's history. This suits an entry known to be
+// newer than the coordinates. ErrAnchorNotFoundOnTarget is returned when the entry is not found.
+func SearchPseudoGTIDEntryInInstanceSince(instance *Instance, entryText string, sinceCoordinates BinlogCoordinates) (*BinlogCoordinates, error) {
+	for _, binlog := range SortBinaryLogs(instance.GetBinaryLogs()) {
+		if binlogFileNameSmallerThan(binlog, sinceCoordinates.LogFile) {
+			continue
+		}
+		startingCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}
+		if binlog == sinceCoordinates.LogFile {
+			startingCoordinates.LogPos = sinceCoordinates.LogPos
+		}
+		resultCoordinates, err := searchPseudoGTIDEntryInBinlogFrom(&instance.Key, startingCoordinates, entryText, nil)
+		if err == nil {
+			return &resultCoordinates, nil
+		}
+		if err != ErrAnchorNotFoundOnTarget {
+			return nil, err
+		}
+	}
+	return nil, ErrAnchorNotFoundOnTarget
+}
+
+// InjectAndVerifyPseudoGTID is an end to end check of the Pseudo-GTID pipeline: it injects a uniquely identifiable
+// Pseudo-GTID entry on given master, via PseudoGTIDInjectionStatement, and waits up to given timeout for the entry
+// to show up in the binary logs of all given slaves (which should therefore have log-slave-updates). Slaves are
+// only searched from their binary log position as of before the injection.
+// The result maps each slave onto the coordinates of the entry, or onto nil where it did not arrive in time, in which
+// case an error is returned as well.
+func InjectAndVerifyPseudoGTID(master *Instance, slaves [](*Instance), timeout time.Duration) (map[InstanceKey]*BinlogCoordinates, error) {
+	if !strings.Contains(config.Config.PseudoGTIDInjectionStatement, "%s") {
+		return nil, log.Errorf("PseudoGTIDInjectionStatement must be configured, with a %%s placeholder for the injected marker")
+	}
+	pseudoGTIDRegexp, err := compilePattern(config.Config.PseudoGTIDPattern)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	marker := fmt.Sprintf("%x", time.Now().UnixNano())
+	statement := fmt.Sprintf(config.Config.PseudoGTIDInjectionStatement, marker)
+	if config.Config.PseudoGTIDPattern == "" || !pseudoGTIDRegexp.MatchString(statement) {
+		return nil, log.Errorf("Injection statement does not match PseudoGTIDPattern: %s", statement)
+	}
+	// The entry can only show up on slaves past their current positions
+	sinceCoordinates := make(map[InstanceKey]BinlogCoordinates)
+	for _, slave := range slaves {
+		refreshedSlave, err := refreshedSelfBinlogCoordinates(slave)
+		if err != nil {
+			return nil, log.Errore(err)
+		}
+		sinceCoordinates[slave.Key] = refreshedSlave.SelfBinlogCoordinates
+	}
+	if _, err := ExecInstance(&master.Key, statement); err != nil {
+		return nil, log.Errore(err)
+	}
+	log.Debugf("Injected pseudo gtid marker %s on %+v", marker, master.Key)
+
+	deadline := time.Now().Add(timeout)
+	// We need the entry as logged by master, which is what the slaves' binary logs hold
+	entryText := ""
+	for entryText == "" {
+		if err := refreshBinaryLogs(master); err != nil {
+			return nil, log.Errore(err)
+		}
+		if _, lastEntryText, err := GetLastPseudoGTIDEntryInInstance(master); err == nil && strings.Contains(lastEntryText, marker) {
+			entryText = lastEntryText
+		} else if time.Now().Add(pseudoGTIDWaitPollInterval).After(deadline) {
+			return nil, log.Errorf("Cannot find injected pseudo gtid marker %s in binary logs of %+v", marker, master.Key)
+		} else {
+			time.Sleep(pseudoGTIDWaitPollInterval)
+		}
+	}
+
+	entryCoordinates := make(map[InstanceKey]*BinlogCoordinates)
+	pendingSlaves := slaves
+	for {
+		stillPendingSlaves := [](*Instance){}
+		for _, slave := range pendingSlaves {
+			if err := refreshBinaryLogs(slave); err != nil {
+				return entryCoordinates, log.Errore(err)
+			}
+			slaveCoordinates, err := SearchPseudoGTIDEntryInInstanceSince(slave, entryText, sinceCoordinates[slave.Key])
+			if err != nil && err != ErrAnchorNotFoundOnTarget {
+				return entryCoordinates, log.Errore(err)
+			}
+			entryCoordinates[slave.Key] = slaveCoordinates
+			if slaveCoordinates == nil {
+				stillPendingSlaves = append(stillPendingSlaves, slave)
+			}
+		}
+		pendingSlaves = stillPendingSlaves
+		if len(pendingSlaves) == 0 {
+			return entryCoordinates, nil
+		}
+		if time.Now().Add(pseudoGTIDWaitPollInterval).After(deadline) {
+			return entryCoordinates, log.Errorf("Injected pseudo gtid marker %s did not reach %d slaves of %+v within %+v", marker, len(pendingSlaves), master.Key, timeout)
+		}
+		time.Sleep(pseudoGTIDWaitPollInterval)
+	}
+}
+
+// SearchPseudoGTIDEntryInInstanceWithProgress is SearchPseudoGTIDEntryInInstance, which further reports progress to
+// onProgress (when non-nil): events scanned so far, accumulated across binary logs, along with an estimate of the
+// remaining time. No progress is reported when the result is served by cache or by another in-progress search.
+func SearchPseudoGTIDEntryInInstanceWithProgress(instance *Instance, entryText string, onProgress func(progress BinlogScanProgress)) (*BinlogCoordinates, error) {
+	cacheKey := getInstancePseudoGTIDKey(instance, entryText)
+	coords, found := getCachedPseudoGTIDEntryCoordinates(instance, entryText)
+	if found {
+		// This is wonderful. We can skip the tedious GTID search in the binary log
+		log.Debugf("Found instance Pseudo GTID entry coordinates in cache: %+v, %+v, %+v", instance.Key, entryText, coords)
+		return coords, nil
+	}
+	// Is anyone else already searching for this very entry? If so, we wait on their result
+	search, searchOwner := getPseudoGTIDSearch(cacheKey)
+	if !searchOwner {
+		log.Debugf("Waiting on in-progress search for pseudo gtid entry in %+v: %+v", instance.Key, entryText)
+		<-search.done
+		return search.coordinates, search.err
+	}
+	defer completePseudoGTIDSearch(cacheKey, search)
+
+	if resultCoordinates, found := searchPseudoGTIDEntryInBucket(instance, entryText); found {
+		log.Debugf("Matched entry in %+v by bucket: %+v", instance.Key, *resultCoordinates)
+		cachePseudoGTIDEntryCoordinates(instance, entryText, resultCoordinates)
+		search.coordinates, search.err = resultCoordinates, nil
+		return search.coordinates, nil
+	}
+	// Look for GTID entry in other-instance:
+	binlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	var previousBinlogsRowsScanned int64
+	var estimatedTotalRows int64
+	if onProgress != nil {
+		if estimate, err := EstimatePseudoGTIDScanCost(instance); err == nil {
+			estimatedTotalRows = estimate.EstimatedEvents
+		}
+	}
+	scanStartTime := time.Now()
+	var scanErr error
+	for i := len(binlogs) - 1; i >= 0; i-- {
+		log.Debugf("Searching for given pseudo gtid entry in binlog %+v of %+v", binlogs[i], instance.Key)
+		var binlogRowsScanned int64
+		var onBinlogProgress func(rowsScanned int64)
+		if onProgress != nil {
+			onBinlogProgress = func(rowsScanned int64) {
+				binlogRowsScanned = rowsScanned
+				onProgress(NewBinlogScanProgress(previousBinlogsRowsScanned+rowsScanned, time.Since(scanStartTime), estimatedTotalRows))
+			}
+		}
+		resultCoordinates, err := SearchPseudoGTIDEntryInBinlogWithProgress(&instance.Key, binlogs[i], entryText, onBinlogProgress)
+		previousBinlogsRowsScanned += binlogRowsScanned
+		if resultCoordinates.LogPos != 0 && err == nil {
+			log.Debugf("Matched entry in %+v: %+v", instance.Key, resultCoordinates)
+			cachePseudoGTIDEntryCoordinates(instance, entryText, &resultCoordinates)
+			search.coordinates, search.err = &resultCoordinates, nil
+			return search.coordinates, nil
+		}
+		if err != nil && err != ErrAnchorNotFoundOnTarget {
+			scanErr = err
+		}
+	}
+	if scanErr != nil {
+		// Some binary log could not be scanned; we cannot tell whether the entry is there or not
+		search.err = log.Errore(scanErr)
+		return nil, search.err
+	}
+	log.Errorf("Cannot match pseudo GTID entry in binlogs of %+v%s", instance.Key, diagnoseMissingPseudoGTID(instance))
+	search.err = ErrAnchorNotFoundOnTarget
+	return nil, search.err
+}
+
+// pseudoGTIDSearch is an in-progress search for a Pseudo-GTID entry in an instance's binary logs.
+// Concurrent identical searches (same instance, same entry) wait on its result rather than scan the binary logs
+// themselves. This complements instancePseudoGTIDEntryCache, which only serves searches that have completed.
+type pseudoGTIDSearch struct {
+	done        chan bool
+	coordinates *BinlogCoordinates
+	err         error
+}
+
+var pseudoGTIDSearches = make(map[string]*pseudoGTIDSearch)
+var pseudoGTIDSearchesChan = make(chan bool, 1)
+
+// getPseudoGTIDSearch returns the in-progress search for given cache key, creating one if there is none.
+// The second return value is true when the search was created by this call, in which case the caller owns it and
+// must complete it via completePseudoGTIDSearch.
+func getPseudoGTIDSearch(cacheKey string) (*pseudoGTIDSearch, bool) {
+	pseudoGTIDSearchesChan <- true
+	defer func() { <-pseudoGTIDSearchesChan }()
+
+	if search, found := pseudoGTIDSearches[cacheKey]; found {
+		return search, false
+	}
+	// The error is overwritten by the owner upon completion; it remains in effect should the owner abort
+	search := &pseudoGTIDSearch{done: make(chan bool), err: errors.New(fmt.Sprintf("Pseudo GTID search aborted: %s", cacheKey))}
+	pseudoGTIDSearches[cacheKey] = search
+	return search, true
+}
+
+// completePseudoGTIDSearch releases all waiters of given search; its result must have been set beforehand
+func completePseudoGTIDSearch(cacheKey string, search *pseudoGTIDSearch) {
+	pseudoGTIDSearchesChan <- true
+	defer func() { <-pseudoGTIDSearchesChan }()
+
+	delete(pseudoGTIDSearches, cacheKey)
+	close(search.done)
 }
 
-// Try and find the last position of a pseudo GTID query entry in the given binary log.
-// Also return the full text of that entry.
-// maxCoordinates is the position beyond which we should not read. This is relevant when reading relay logs; in particular,
-// the last relay log. We must be careful not to scan for Pseudo-GTID entries past the position executed by the SQL thread.
-// maxCoordinates == nil means no limit.
-func getLastPseudoGTIDEntryInBinlog(instanceKey *InstanceKey, binlog string, binlogType BinlogType, maxCoordinates *BinlogCoordinates) (*BinlogCoordinates, string, error) {
-	binlogCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: binlogType}
-	db, err := db.OpenTopology(instanceKey.Hostname, instanceKey.Port)
-	if err != nil {
-		return nil, "", err
+// ClearPseudoGTIDCacheForInstance removes all cached Pseudo-GTID entry coordinates of given instance. This is useful
+// after manual intervention on the instance (rebuild, RESET MASTER) which invalidates its binary log coordinates.
+// It returns the number of removed entries.
+func ClearPseudoGTIDCacheForInstance(instanceKey *InstanceKey) int {
+	return ClearPseudoGTIDCacheForInstances([]InstanceKey{*instanceKey})
+}
+
+// ClearPseudoGTIDCacheForInstances removes all cached Pseudo-GTID entry coordinates of given instances, in a single
+// pass over the cache. This supports bulk maintenance, such as a cluster-wide reset.
+// It returns the number of removed entries.
+func ClearPseudoGTIDCacheForInstances(instanceKeys []InstanceKey) int {
+	instanceDisplayStrings := make(map[string]bool)
+	for _, instanceKey := range instanceKeys {
+		instanceDisplayStrings[instanceKey.DisplayString()] = true
+	}
+	removedCount := 0
+	for _, entryCache := range []*cache.Cache{instancePseudoGTIDEntryCache, pseudoGTIDBucketCache} {
+		for cacheKey := range entryCache.Items() {
+			tokens := strings.SplitN(cacheKey, ";", 2)
+			if instanceDisplayStrings[tokens[0]] {
+				entryCache.Delete(cacheKey)
+				removedCount++
+			}
+		}
 	}
+	log.Debugf("Cleared %d pseudo gtid cache entries of %d instances", removedCount, len(instanceKeys))
+	return removedCount
+}
 
-	moreRowsExpected := true
-	step := 0
+// CountEventsBetweenAnchors returns the number of events found between two Pseudo-GTID entries (exclusive) in the
+// binary logs of given instance; anchorA must precede anchorB. Meta/control events (e.g. rotation) are not counted.
+// This quantifies the write volume between Pseudo-GTID injections.
+func CountEventsBetweenAnchors(instance *Instance, anchorA BinlogCoordinates, anchorB BinlogCoordinates) (int64, error) {
+	if anchorB.SmallerThan(&anchorA) {
+		return 0, log.Errorf("CountEventsBetweenAnchors: %+v precedes %+v", anchorB, anchorA)
+	}
+	fetchNextEvents := func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+		return getNextBinlogEventsChunk(instance, binlogCoordinates)
+	}
+	cursor := NewBinlogEventCursor(anchorA, fetchNextEvents)
 
-	entryText := ""
-	commandToken := math.TernaryString(binlogCoordinates.Type == BinaryLog, "binlog", "relaylog")
-	for moreRowsExpected {
-		query := fmt.Sprintf("show %s events in '%s' LIMIT %d,%d", commandToken, binlog, (step * binlogEventsChunkSize), binlogEventsChunkSize)
-
-		moreRowsExpected = false
-		err = sqlutils.QueryRowsMap(db, query, func(m sqlutils.RowMap) error {
-			moreRowsExpected = true
-			binlogEntryInfo := m.GetString("Info")
-			if matched, _ := regexp.MatchString(config.Config.PseudoGTIDPattern, binlogEntryInfo); matched {
-				if maxCoordinates != nil && maxCoordinates.SmallerThan(&BinlogCoordinates{LogFile: binlog, LogPos: m.GetInt64("Pos")}) {
-					// past the limitation
-					moreRowsExpected = false
-					return nil
-				}
-				binlogCoordinates.LogPos = m.GetInt64("Pos")
-				entryText = binlogEntryInfo
-				// Found a match. But we keep searching: we're interested in the LAST entry, and, alas,
-				// we can only search in ASCENDING order...
-			}
-			return nil
-		})
+	var count int64
+	for {
+		event, err := cursor.NextRealEvent()
 		if err != nil {
-			return nil, "", err
+			return count, log.Errore(err)
+		}
+		if event == nil {
+			return count, log.Errorf("CountEventsBetweenAnchors: reached end of binary logs of %+v without reaching %+v", instance.Key, anchorB)
+		}
+		if event.Coordinates.Equals(&anchorB) {
+			return count, nil
+		}
+		if anchorB.SmallerThan(&event.Coordinates) {
+			return count, log.Errorf("CountEventsBetweenAnchors: passed %+v without hitting it; is it an event's position?", anchorB)
+		}
+		if !event.Coordinates.Equals(&anchorA) {
+			count++
 		}
-		step++
 	}
+}
 
-	// Not found? return nil. an error is reserved to SQL problems.
-	if binlogCoordinates.LogPos == 0 {
-		return nil, "", nil
+// GetBinlogEventAtCoordinates reads the single binary/relay log event found at given coordinates.
+// It returns nil when no such event is found.
+func GetBinlogEventAtCoordinates(instanceKey *InstanceKey, coordinates *BinlogCoordinates) (*BinlogEvent, error) {
+	events, err := readBinlogEventsChunk(instanceKey, *coordinates, 1)
+	if err != nil {
+		return nil, err
 	}
-	return &binlogCoordinates, entryText, err
+	if len(events) == 0 {
+		return nil, nil
+	}
+	if !events[0].Coordinates.Equals(coordinates) {
+		// We're not positioned on an event boundary
+		return nil, nil
+	}
+	return &events[0], nil
 }
 
-func GetLastPseudoGTIDEntryInInstance(instance *Instance) (*BinlogCoordinates, string, error) {
-	// Look for last GTID in instance:
-	instanceBinlogs := instance.GetBinaryLogs()
+// PeekBinlogEvents reads up to limit events of a single binary/relay log, starting at given coordinates. This is
+// a cheap way of reading a handful of events, e.g. the header events (Format_desc, Previous_gtids) of a binary log.
+func PeekBinlogEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int) ([]BinlogEvent, error) {
+	return readBinlogEventsChunk(instanceKey, startingCoordinates, limit)
+}
 
-	for i := len(instanceBinlogs) - 1; i >= 0; i-- {
-		log.Debugf("Searching for latest pseudo gtid entry in binlog %+v of %+v", instanceBinlogs[i], instance.Key)
-		resultCoordinates, entryInfo, err := getLastPseudoGTIDEntryInBinlog(&instance.Key, instanceBinlogs[i], BinaryLog, nil)
+// binlogHeaderEventsCount is the number of events, at the beginning of a binary log, within which the header events
+// (Format_desc, Previous_gtids) are expected
+const binlogHeaderEventsCount = 3
+
+// GetBinlogPreviousGTIDs returns the GTID set of the Previous_gtids event of given binary log: the set of GTIDs
+// executed before the binary log began. An empty set is valid (e.g. GTID never having been enabled).
+// Servers with no GTID support write no such event, which results in an error.
+func GetBinlogPreviousGTIDs(instance *Instance, binlog string) (string, error) {
+	events, err := PeekBinlogEvents(&instance.Key, BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}, binlogHeaderEventsCount)
+	if err != nil {
+		return "", err
+	}
+	for _, event := range events {
+		if event.EventType == "Previous_gtids" {
+			return strings.Replace(strings.TrimSpace(event.Info), "\n", "", -1), nil
+		}
+	}
+	return "", log.Errorf("GetBinlogPreviousGTIDs: no Previous_gtids event found in %+v of %+v", binlog, instance.Key)
+}
+
+// BinlogTimeRanges returns the time span of each of the instance's binary logs: a map of binary log retention,
+// useful for point in time recovery planning. Only the first event of each binary log is read: a binary log is
+// rotated right upon writing its last event, hence its last event's timestamp is taken to be that of the next
+// binary log's first event.
+// Event timestamps are only available when binary logs are read off disk or via mysqlbinlog.
+func BinlogTimeRanges(instance *Instance) ([]BinlogTimeRange, error) {
+	binlogs := SortBinaryLogs(instance.GetBinaryLogs())
+	timeRanges := []BinlogTimeRange{}
+	for _, binlog := range binlogs {
+		events, err := PeekBinlogEvents(&instance.Key, BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}, 1)
 		if err != nil {
-			return nil, "", err
+			return timeRanges, err
 		}
-		if resultCoordinates != nil {
-			log.Debugf("Found pseudo gtid entry in %+v: %+v", instance.Key, resultCoordinates)
-			return resultCoordinates, entryInfo, err
+		if len(events) == 0 {
+			return timeRanges, log.Errorf("BinlogTimeRanges: no events found in %+v of %+v", binlog, instance.Key)
+		}
+		if events[0].Timestamp.IsZero() {
+			return timeRanges, log.Errorf("BinlogTimeRanges: event timestamps unavailable on %+v. Configure BinlogFilesDirectories or BinlogReaderMethod", instance.Key)
 		}
+		if len(timeRanges) > 0 {
+			timeRanges[len(timeRanges)-1].LastEventTimestamp = events[0].Timestamp
+		}
+		timeRanges = append(timeRanges, BinlogTimeRange{LogFile: binlog, FirstEventTimestamp: events[0].Timestamp})
 	}
-	return nil, "", log.Errorf("Cannot find pseudo GTID entry in binlogs of %+v", instance.Key)
+	return timeRanges, nil
 }
 
-func GetLastPseudoGTIDEntryInRelayLogs(instance *Instance, recordedInstanceRelayLogCoordinates BinlogCoordinates) (*BinlogCoordinates, string, error) {
-	// Look for last GTID in relay logs:
-	// Since MySQL does not provide with a SHOW RELAY LOGS command, we heuristically srtart from current
-	// relay log (indiciated by Relay_log_file) and walk backwards.
-	// Eventually we will hit a relay log name which does not exist.
-	currentRelayLog := recordedInstanceRelayLogCoordinates
-	var err error = nil
-	for err == nil {
-		log.Debugf("Searching for latest pseudo gtid entry in relaylog %+v of %+v, up to pos %+v", currentRelayLog.LogFile, instance.Key, recordedInstanceRelayLogCoordinates)
-		if resultCoordinates, entryInfo, err := getLastPseudoGTIDEntryInBinlog(&instance.Key, currentRelayLog.LogFile, RelayLog, &recordedInstanceRelayLogCoordinates); err != nil {
-			return nil, "", err
-		} else if resultCoordinates != nil {
-			log.Debugf("Found pseudo gtid entry in %+v: %+v", instance.Key, resultCoordinates)
-			return resultCoordinates, entryInfo, err
-		}
-		currentRelayLog, err = currentRelayLog.PreviousFileCoordinates()
+// pseudoGTIDEntryHolds checks whether the event at given coordinates is the given entry. Errors count as "no".
+func pseudoGTIDEntryHolds(instanceKey *InstanceKey, coordinates *BinlogCoordinates, entryText string) bool {
+	event, err := GetBinlogEventAtCoordinates(instanceKey, coordinates)
+	return err == nil && event != nil && EventInfoEquals(event.Info, entryText)
+}
+
+// getCachedPseudoGTIDEntryCoordinates looks up the cached coordinates of given entry on given instance.
+// With VerifyPseudoGTIDCacheHits, a hit is confirmed by reading the event at the cached coordinates; a stale
+// entry is evicted and reported as a miss.
+func getCachedPseudoGTIDEntryCoordinates(instance *Instance, entryText string) (*BinlogCoordinates, bool) {
+	cacheKey := getInstancePseudoGTIDKey(instance, entryText)
+	coords, found := instancePseudoGTIDEntryCache.Get(cacheKey)
+	if !found {
+		return nil, false
 	}
-	return nil, "", log.Errorf("Cannot find pseudo GTID entry in relay logs of %+v", instance.Key)
+	coordinates := coords.(*BinlogCoordinates)
+	if config.Config.VerifyPseudoGTIDCacheHits && !pseudoGTIDEntryHolds(&instance.Key, coordinates, entryText) {
+		log.Debugf("Evicting stale pseudo gtid cache entry: %+v, %+v, %+v", instance.Key, entryText, *coordinates)
+		instancePseudoGTIDEntryCache.Delete(cacheKey)
+		return nil, false
+	}
+	return coordinates, true
 }
 
-// Given a binlog entry text (query), search it in the given binary log of a given instance
-func SearchPseudoGTIDEntryInBinlog(instanceKey *InstanceKey, binlog string, entryText string) (BinlogCoordinates, error) {
-	binlogCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}
-	db, err := db.OpenTopology(instanceKey.Hostname, instanceKey.Port)
+// WarmPseudoGTIDCache looks up the latest Pseudo-GTID entry of each known instance with binary logs, and caches
+// its coordinates, so that matching operations (e.g. upon failover) find them readily. Instances are scanned one
+// at a time, to keep the load light, and those scanned within the past PseudoGTIDCacheWarmMinutes are skipped.
+// Should a previous run still be in progress, this run is skipped altogether.
+// It returns the number of instances scanned.
+func WarmPseudoGTIDCache() int {
+	select {
+	case pseudoGTIDCacheWarmingChan <- true:
+		defer func() { <-pseudoGTIDCacheWarmingChan }()
+	default:
+		log.Debugf("Pseudo gtid cache warming already in progress; skipping")
+		return 0
+	}
+	clusters, err := ReadClusters()
 	if err != nil {
-		return binlogCoordinates, err
+		log.Errore(err)
+		return 0
 	}
+	warmedCount := 0
+	for _, clusterName := range clusters {
+		instances, err := ReadClusterInstances(clusterName)
+		if err != nil {
+			log.Errore(err)
+			continue
+		}
+		for _, instance := range instances {
+			if !instance.LogBinEnabled {
+				continue
+			}
+			if _, found := pseudoGTIDCacheWarmedInstances.Get(instance.Key.DisplayString()); found {
+				continue
+			}
+			pseudoGTIDCacheWarmedInstances.Set(instance.Key.DisplayString(), true, time.Duration(config.Config.PseudoGTIDCacheWarmMinutes)*time.Minute)
+			warmedCount++
 
-	moreRowsExpected := true
-	step := 0
-
-	commandToken := math.TernaryString(binlogCoordinates.Type == BinaryLog, "binlog", "relaylog")
-	for moreRowsExpected {
-		query := fmt.Sprintf("show %s events in '%s' LIMIT %d,%d", commandToken, binlog, (step * binlogEventsChunkSize), binlogEventsChunkSize)
-		moreRowsExpected = false
-		err = sqlutils.QueryRowsMap(db, query, func(m sqlutils.RowMap) error {
-			if binlogCoordinates.LogPos != 0 {
-				return nil
-				// moreRowsExpected reamins false, this quits the loop
+			if err := refreshBinaryLogs(instance); err != nil {
+				log.Errore(err)
+				continue
 			}
-			moreRowsExpected = true
-			if m.GetString("Info") == entryText {
-				// found it!
-				binlogCoordinates.LogPos = m.GetInt64("Pos")
+			coordinates, entryText, err := GetLastPseudoGTIDEntryInInstance(instance)
+			if err != nil {
+				continue
 			}
-			return nil
-		})
-		if err != nil {
-			return binlogCoordinates, err
+			cachePseudoGTIDEntryCoordinates(instance, entryText, coordinates)
 		}
-		step++
 	}
-
-	if binlogCoordinates.LogPos == 0 {
-		return binlogCoordinates, errors.New(fmt.Sprintf("Cannot match pseudo GTID entry in binlog '%s'", binlog))
-	}
-	return binlogCoordinates, err
+	log.Debugf("Warmed pseudo gtid cache; scanned %d instances", warmedCount)
+	return warmedCount
 }
 
-func SearchPseudoGTIDEntryInInstance(instance *Instance, entryText string) (*BinlogCoordinates, error) {
-	cacheKey := getInstancePseudoGTIDKey(instance, entryText)
-	coords, found := instancePseudoGTIDEntryCache.Get(cacheKey)
-	if found {
-		// This is wonderful. We can skip the tedious GTID search in the binary log
-		log.Debugf("Found instance Pseudo GTID entry coordinates in cache: %+v, %+v, %+v", instance.Key, entryText, coords)
-		return coords.(*BinlogCoordinates), nil
+// VerifyPseudoGTIDCache re-checks each cached (instance, entry) -> coordinates mapping against the live binary logs,
+// and evicts entries which no longer hold (binary logs purged, reset master, position reuse...). An entry which
+// cannot be verified due to error is evicted as well: we'd rather rescan than trust it.
+// Should a previous run still be in progress, this run is skipped altogether.
+// It returns the number of evicted entries.
+func VerifyPseudoGTIDCache() int {
+	select {
+	case pseudoGTIDCacheVerifyingChan <- true:
+		defer func() { <-pseudoGTIDCacheVerifyingChan }()
+	default:
+		log.Debugf("Pseudo gtid cache verification already in progress; skipping")
+		return 0
 	}
-	// Look for GTID entry in other-instance:
-	binlogs := instance.GetBinaryLogs()
-	for i := len(binlogs) - 1; i >= 0; i-- {
-		log.Debugf("Searching for given pseudo gtid entry in binlog %+v of %+v", binlogs[i], instance.Key)
-		resultCoordinates, err := SearchPseudoGTIDEntryInBinlog(&instance.Key, binlogs[i], entryText)
-		if resultCoordinates.LogPos != 0 && err == nil {
-			log.Debugf("Matched entry in %+v: %+v", instance.Key, resultCoordinates)
-			instancePseudoGTIDEntryCache.Set(cacheKey, &resultCoordinates, 0)
-			return &resultCoordinates, nil
+	evictedCount := 0
+	for cacheKey, item := range instancePseudoGTIDEntryCache.Items() {
+		coordinates, ok := item.Object.(*BinlogCoordinates)
+		if !ok {
+			continue
+		}
+		instanceKey, entryText, err := parseInstancePseudoGTIDKey(cacheKey)
+		if err != nil {
+			log.Errore(err)
+			instancePseudoGTIDEntryCache.Delete(cacheKey)
+			evictedCount++
+			continue
+		}
+		if !pseudoGTIDEntryHolds(instanceKey, coordinates, entryText) {
+			log.Debugf("Evicting stale pseudo gtid cache entry: %+v, %+v, %+v", *instanceKey, entryText, *coordinates)
+			instancePseudoGTIDEntryCache.Delete(cacheKey)
+			evictedCount++
 		}
 	}
-	return nil, log.Errorf("Cannot match pseudo GTID entry in binlogs of %+v", instance.Key)
+	log.Debugf("Verified pseudo gtid cache; evicted %d entries", evictedCount)
+	return evictedCount
 }
 
-// Read (as much as possible of) a chink of binary log events starting the given startingCoordinates
-func readBinlogEventsChunk(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+// Read (as much as possible of) a chink of binary log events starting the given startingCoordinates.
+// At most limit events are read; a non-positive limit stands for binlogEventsChunkSize.
+// Each chunk read is a registered scan: it is subject to AbortBinlogScans, and does not begin while scans are
+// paused. Chunked iterations such as the matcher's thus pause in between chunks.
+func readBinlogEventsChunk(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int) ([]BinlogEvent, error) {
+	if limit <= 0 {
+		limit = binlogEventsChunkSize
+	}
 	events := []BinlogEvent{}
-	db, err := db.OpenTopology(instanceKey.Hostname, instanceKey.Port)
+	reader, err := getBinlogReader(instanceKey)
 	if err != nil {
 		return events, err
 	}
-	commandToken := math.TernaryString(startingCoordinates.Type == BinaryLog, "binlog", "relaylog")
-	query := fmt.Sprintf("show %s events in '%s' FROM %d LIMIT %d", commandToken, startingCoordinates.LogFile, startingCoordinates.LogPos, binlogEventsChunkSize)
-	err = sqlutils.QueryRowsMap(db, query, func(m sqlutils.RowMap) error {
-		binlogEvent := BinlogEvent{}
-		binlogEvent.Coordinates.LogFile = m.GetString("Log_name")
-		binlogEvent.Coordinates.LogPos = m.GetInt64("Pos")
-		binlogEvent.Coordinates.Type = startingCoordinates.Type
-		binlogEvent.NextEventPos = m.GetInt64("End_log_pos")
-		binlogEvent.EventType = m.GetString("Event_type")
-		binlogEvent.Info = m.GetString("Info")
+	registration := RegisterScan(fmt.Sprintf("%+v %+v", *instanceKey, startingCoordinates))
+	defer registration.Unregister()
+	if err := registration.WaitWhilePaused(); err != nil {
+		return events, err
+	}
 
+	err = reader.ReadEvents(instanceKey, startingCoordinates, limit, func(binlogEvent BinlogEvent) error {
+		if registration.Aborted() {
+			return ErrBinlogScanAborted
+		}
 		events = append(events, binlogEvent)
 		return nil
 	})
+	if err == nil && config.Config.BinlogEventsStrictPositions && startingCoordinates.Type == BinaryLog {
+		err = validateBinlogEventsPositions(instanceKey, events)
+	}
 	return events, err
 }
 
+// validateBinlogEventsPositions verifies that each of given binary log events ends beyond its own position, and
+// that End_log_pos strictly increases from one event to the next. Relay log events are not applicable, since their
+// End_log_pos refers to the master's binary log.
+func validateBinlogEventsPositions(instanceKey *InstanceKey, events []BinlogEvent) error {
+	for i, event := range events {
+		if event.NextEventPos <= event.Coordinates.LogPos {
+			return log.Errorf("Invalid binlog event position on %+v: %+v %s event ends at %d, not beyond its position", *instanceKey, event.Coordinates, event.EventType, event.NextEventPos)
+		}
+		if i > 0 && event.NextEventPos <= events[i-1].NextEventPos {
+			return log.Errorf("Invalid binlog event position on %+v: %+v %s event ends at %d, not beyond previous event's end at %d", *instanceKey, event.Coordinates, event.EventType, event.NextEventPos, events[i-1].NextEventPos)
+		}
+	}
+	return nil
+}
+
 // Return the next chunk of binlog events; skip to next binary log file if need be; return empty result only
 // if reached end of binary logs
+// This iterates (rather than recurses) over successive empty binary logs, of which there may be many.
 func getNextBinlogEventsChunk(instance *Instance, startingCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
-	events, err := readBinlogEventsChunk(&instance.Key, startingCoordinates)
+	for {
+		events, err := readBinlogEventsChunk(&instance.Key, startingCoordinates, binlogEventsChunkSize)
+		if err != nil {
+			return events, err
+		}
+		if len(events) > 0 {
+			return events, nil
+		}
+		// events are empty
+		nextBinlogFile, err := instance.GetNextBinaryLog(startingCoordinates.LogFile)
+		if err != nil && startingCoordinates.Type == BinaryLog {
+			// The instance's list of binary logs may lag behind a just-rotated binary log
+			nextBinlogFile, err = guessNextBinaryLog(instance, startingCoordinates.LogFile)
+		}
+		if err != nil {
+			// No more log file. We return the empty array: but no error, since there is no error; we've just reached the end.
+			// This behaviour is strictly expected by BinlogEventCursor
+			if config.Config.BinlogScanVerifyEndOfLog && startingCoordinates.Type == BinaryLog {
+				return verifyEndOfBinaryLogs(instance, startingCoordinates)
+			}
+			return events, nil
+		}
+		startingCoordinates = BinlogCoordinates{LogFile: nextBinlogFile, LogPos: 0, Type: startingCoordinates.Type}
+	}
+}
+
+// isTransientBinlogFetchError returns true for errors which may well not recur on retry: a dropped or broken
+// connection, or a network error. Any other error, be it reported by the server itself (e.g. a purged binary log)
+// or one of our own verdicts (e.g. an open circuit), is final.
+func isTransientBinlogFetchError(err error) bool {
+	switch err {
+	case driver.ErrBadConn, mysql.ErrInvalidConn, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return false
+}
+
+// retryingBinlogEventsFetcher wraps the fetch function of a BinlogEventCursor such that a fetch failing on a
+// transient error is retried, up to BinlogMatchFetchRetries times, rather than have a long match discarded over a
+// brief network blip. A retry refetches the very same coordinates, keeping the cursor in place; database/sql
+// replaces the broken connection on its own. The circuit breaker still applies to each attempt.
+func retryingBinlogEventsFetcher(instanceKey *InstanceKey, fetch func(BinlogCoordinates) ([]BinlogEvent, error)) func(BinlogCoordinates) ([]BinlogEvent, error) {
+	return func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+		for attempt := uint(1); ; attempt++ {
+			events, err := fetch(binlogCoordinates)
+			if err == nil || attempt > config.Config.BinlogMatchFetchRetries || !isTransientBinlogFetchError(err) {
+				return events, err
+			}
+			log.Warningf("Failed fetching binlog events at %+v on %+v: %+v. Retrying (%d/%d)", binlogCoordinates, *instanceKey, err, attempt, config.Config.BinlogMatchFetchRetries)
+			time.Sleep(binlogMatchFetchRetryInterval)
+		}
+	}
+}
+
+// guessNextBinaryLog constructs the name of the binary log following given one, by incrementing its numeric suffix,
+// and verifies via SHOW BINARY LOGS that it exists. This covers for binary logs rotated after the instance was read.
+func guessNextBinaryLog(instance *Instance, binlog string) (string, error) {
+	currentCoordinates := BinlogCoordinates{LogFile: binlog, LogPos: 0, Type: BinaryLog}
+	nextCoordinates, err := currentCoordinates.NextFileCoordinates()
+	if err != nil {
+		return "", err
+	}
+	binaryLogFiles, err := ReadBinaryLogFiles(&instance.Key)
+	if err != nil {
+		return "", err
+	}
+	for _, binaryLogFile := range binaryLogFiles {
+		if binaryLogFile.LogFile == nextCoordinates.LogFile {
+			log.Debugf("Found binary log %s of %+v, not yet known to orchestrator", nextCoordinates.LogFile, instance.Key)
+			return nextCoordinates.LogFile, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("Cannot find next binary log for %s", binlog))
+}
+
+// readMasterStatusCoordinates reads the current binary log coordinates of given instance via SHOW MASTER STATUS.
+// nil is returned for an instance with binary logging disabled.
+func readMasterStatusCoordinates(instanceKey *InstanceKey) (*BinlogCoordinates, error) {
+	db, err := openTopologyForBinlogScan(instanceKey)
+	if err != nil {
+		return nil, err
+	}
+	var masterStatusCoordinates *BinlogCoordinates
+	err = sqlutils.QueryRowsMap(db, "show master status", func(m sqlutils.RowMap) error {
+		masterStatusCoordinates = &BinlogCoordinates{LogFile: m.GetString("File"), LogPos: m.GetInt64("Position"), Type: BinaryLog}
+		return nil
+	})
+	return masterStatusCoordinates, err
+}
+
+// refreshedSelfBinlogCoordinates returns a copy of given instance, with its binary logs and current binary log
+// coordinates re-read, since these may have been rotated since the instance was read. It verifies the two agree: the
+// newest binary log must be the one holding the current coordinates. A rotation in between the two reads is retried
+// once, after a short pause. Given instance is left as is.
+func refreshedSelfBinlogCoordinates(instance *Instance) (*Instance, error) {
+	refreshedInstance := *instance
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(selfBinlogCoordinatesRetryInterval)
+		}
+		if err := refreshBinaryLogs(&refreshedInstance); err != nil {
+			return nil, err
+		}
+		masterStatusCoordinates, err := readMasterStatusCoordinates(&instance.Key)
+		if err != nil {
+			return nil, err
+		}
+		if masterStatusCoordinates == nil {
+			return nil, log.Errorf("Cannot refresh self coordinates of %+v: no master status", instance.Key)
+		}
+		refreshedInstance.SelfBinlogCoordinates = *masterStatusCoordinates
+		newestBinlog := ""
+		if binlogs := SortBinaryLogs(refreshedInstance.GetBinaryLogs()); len(binlogs) > 0 {
+			newestBinlog = binlogs[len(binlogs)-1]
+		}
+		if newestBinlog == masterStatusCoordinates.LogFile {
+			return &refreshedInstance, nil
+		}
+		if attempt > 0 {
+			return nil, log.Errorf("Binary logs of %+v disagree with master status: newest binary log is %s, master status is %+v", instance.Key, newestBinlog, *masterStatusCoordinates)
+		}
+		log.Debugf("Binary logs of %+v disagree with master status: newest binary log is %s, master status is %+v. Retrying", instance.Key, newestBinlog, *masterStatusCoordinates)
+	}
+}
+
+// verifyEndOfBinaryLogs is called upon reading no events at given coordinates, past the last known binary log.
+// It re-confirms with SHOW MASTER STATUS that these coordinates are indeed the end of the binary logs, since an
+// empty result may also be the outcome of some transient issue. If the server claims there's more, the chunk is
+// read once again; should it still come out empty, this is an error.
+// Returns the events read (normally none) as per getNextBinlogEventsChunk.
+func verifyEndOfBinaryLogs(instance *Instance, coordinates BinlogCoordinates) ([]BinlogEvent, error) {
+	events := []BinlogEvent{}
+	masterStatusCoordinates, err := readMasterStatusCoordinates(&instance.Key)
 	if err != nil {
 		return events, err
 	}
-	if len(events) > 0 {
+	if masterStatusCoordinates == nil {
+		return events, log.Errorf("Cannot verify end of binary logs on %+v: no master status", instance.Key)
+	}
+	if !coordinates.SmallerThan(masterStatusCoordinates) {
+		// Verified: we're at the end
 		return events, nil
 	}
-	// events are empty
-	if nextBinlogFile, err := instance.GetNextBinaryLog(startingCoordinates.LogFile); err == nil {
-		nextCoordinates := BinlogCoordinates{LogFile: nextBinlogFile, LogPos: 0, Type: startingCoordinates.Type}
-		return getNextBinlogEventsChunk(instance, nextCoordinates)
+	log.Debugf("Read no events at %+v on %+v, but master status is %+v. Retrying", coordinates, instance.Key, *masterStatusCoordinates)
+	events, err = readBinlogEventsChunk(&instance.Key, coordinates, binlogEventsChunkSize)
+	if err != nil {
+		return events, err
+	}
+	if len(events) == 0 {
+		return events, log.Errorf("Premature end of binary logs on %+v at %+v; master status is %+v", instance.Key, coordinates, *masterStatusCoordinates)
 	}
-	// No more log file. We return the empty array: but no error, since there is no error; we've just reached the end.
-	// This behaviour is strictly expected by BinlogEventCursor
 	return events, nil
 }
 
@@ -238,31 +1625,222 @@ func getNextBinlogEventsChunk(instance *Instance, startingCoordinates BinlogCoor
 // If "other" runs out that means "instance" is more advanced in replication than "other", in which case we can't
 // turn it into a slave of "other".
 // Otherwise "instance" will point to the *next* binlog entry in "other"
+// Either side may be read off relay logs, as per the type of its coordinates. When "other" is, the resulting
+// coordinates are in other's relay logs.
+// Events are compared using given comparator; a nil comparator stands for the configured default (see GetEventComparator).
+// See MatchBinlogCoordinatesWithAudit for a variant recording each compared pair of events.
 func GetNextBinlogCoordinatesToMatch(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates,
-	other *Instance, otherCoordinates BinlogCoordinates) (*BinlogCoordinates, error) {
+	other *Instance, otherCoordinates BinlogCoordinates, comparator EventComparator) (*BinlogCoordinates, error) {
+	result, err := MatchBinlogCoordinatesWithAudit(instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, other, otherCoordinates, comparator, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &result.TargetCoordinates, nil
+}
 
-	fetchNextEvents := func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
-		return getNextBinlogEventsChunk(instance, binlogCoordinates)
+// peekTargetGTID returns the GTID of the event found at the target coordinates of a match, if that event is
+// a GTID event; otherwise (or upon error) an empty string. The cursor is expected to be positioned just before
+// the target coordinates; it is consumed by this function.
+func peekTargetGTID(cursor *BinlogEventCursor, targetCoordinates BinlogCoordinates) string {
+	event, err := cursor.NextEvent()
+	if err != nil || event == nil {
+		return ""
 	}
-	instanceCursor := NewBinlogEventCursor(instanceCoordinates, fetchNextEvents)
+	if !event.Coordinates.Equals(&targetCoordinates) {
+		return ""
+	}
+	return event.ParsedGTID()
+}
+
+// getMatchTargetCoordinates returns the coordinates on "other" at which a match concludes, i.e. those following the
+// last matched event, along with the GTID of the event found there, if any.
+// With binary logs, these are the cursor's next coordinates. With relay logs, End_log_pos refers to the master's
+// binary log, and so the next event is read off the cursor, for its coordinates. Since there is no telling where
+// the last event of a relay log ends, a match consuming all of other's relay log events is an error.
+func getMatchTargetCoordinates(otherCursor *BinlogEventCursor, otherLogType BinlogType) (BinlogCoordinates, string, error) {
+	if otherLogType == RelayLog {
+		event, err := otherCursor.NextEvent()
+		if err != nil {
+			return BinlogCoordinates{}, "", err
+		}
+		if event == nil {
+			return BinlogCoordinates{}, "", errors.New("Reached end of relay logs of assumed master; cannot determine target coordinates")
+		}
+		return event.Coordinates, event.ParsedGTID(), nil
+	}
+	targetCoordinates, err := otherCursor.NextCoordinates()
+	if err != nil {
+		return targetCoordinates, "", err
+	}
+	return targetCoordinates, peekTargetGTID(otherCursor, targetCoordinates), nil
+}
 
-	fetchOtherNextEvents := func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+// selfCoordinatesWithinTolerance checks whether the coordinates at which the scan of an instance's binary logs ended
+// are close enough to the instance's recorded self coordinates, as per BinlogMatchSelfCoordinatesTolerance.
+// Only coordinates within the same binary log are considered close.
+func selfCoordinatesWithinTolerance(endCoordinates BinlogCoordinates, selfCoordinates BinlogCoordinates) bool {
+	if config.Config.BinlogMatchSelfCoordinatesTolerance <= 0 {
+		return false
+	}
+	if endCoordinates.LogFile != selfCoordinates.LogFile {
+		return false
+	}
+	gap := endCoordinates.LogPos - selfCoordinates.LogPos
+	if gap < 0 {
+		gap = -gap
+	}
+	return gap <= config.Config.BinlogMatchSelfCoordinatesTolerance
+}
+
+// TranslateBinlogCoordinates translates coordinates in instance's binary logs onto the equivalent coordinates in
+// other's binary logs, where other is an upstream of instance (e.g. instance's master). Coordinates are typically
+// those at which a slave of instance is to resume replication; the result is where that slave would resume
+// replication from other. Translation is based on the Pseudo-GTID entry preceding the coordinates on instance,
+// followed by a match of both up to the coordinates; it is a single hop of the matcher, comparing events alike.
+func TranslateBinlogCoordinates(instance *Instance, coordinates BinlogCoordinates, other *Instance) (*BinlogCoordinates, error) {
+	if instance.Key.Equals(&other.Key) {
+		return nil, log.Errore(ErrSameInstance)
+	}
+	// An entry positioned exactly at the coordinates is yet to be replicated, hence not a valid anchor
+	maxCoordinates := coordinates
+	maxCoordinates.LogPos--
+	instanceAnchorCoordinates, anchorText, err := FindNearestPseudoGTIDBeforeCoordinates(instance, maxCoordinates)
+	if err != nil {
+		return nil, err
+	}
+	otherAnchorCoordinates, err := SearchPseudoGTIDEntryInInstance(other, anchorText)
+	if err != nil {
+		return nil, err
+	}
+	result, err := matchBinlogCoordinatesUpTo(instance, *instanceAnchorCoordinates, BinlogCoordinates{}, &coordinates, other, *otherAnchorCoordinates, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Translated %+v:%+v to %+v:%+v", instance.Key, coordinates, other.Key, result.TargetCoordinates)
+	return &result.TargetCoordinates, nil
+}
+
+// lookaheadForEvent reads up to lookahead real events off given cursor, looking for an event equal to given
+// event, as per comparator. If found, that event is returned, and it along with the events preceding it are consumed.
+// Otherwise all events read are returned to the cursor, and nil is returned.
+// withinScan limits the lookahead to events the caller is allowed to consume.
+func lookaheadForEvent(cursor *BinlogEventCursor, targetEvent BinlogEvent, comparator EventComparator, lookahead uint, withinScan func(event *BinlogEvent) bool) (*BinlogEvent, error) {
+	readEvents := []BinlogEvent{}
+	for i := uint(0); i < lookahead; i++ {
+		event, err := cursor.NextRealEvent()
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			break
+		}
+		readEvents = append(readEvents, *event)
+		if !withinScan(event) {
+			break
+		}
+		if comparator.Equal(*event, targetEvent) {
+			return &readEvents[len(readEvents)-1], nil
+		}
+	}
+	cursor.unreadEvents(readEvents)
+	return nil, nil
+}
+
+// MatchBinlogCoordinates is the workhorse of GetNextBinlogCoordinatesToMatch. Other than the target coordinates
+// on "other", it also reports where the scan on "instance" ended, so that callers may log and verify both sides.
+func MatchBinlogCoordinates(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates) (*BinlogCoordinatesMatchResult, error) {
+	return MatchBinlogCoordinatesWithAudit(instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, other, otherCoordinates, nil, nil)
+}
+
+// MatchBinlogCoordinatesWithAudit is MatchBinlogCoordinates, which further writes a line per compared pair of events
+// onto auditWriter (when non-nil): instance coordinates, other coordinates, and whether the two matched.
+// This makes for a complete account of what the matcher saw, independent of the debug log.
+// Events are compared using given comparator; a nil comparator stands for the configured default.
+func MatchBinlogCoordinatesWithAudit(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates, comparator EventComparator, auditWriter io.Writer) (*BinlogCoordinatesMatchResult, error) {
+	return matchBinlogCoordinatesUpTo(instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, nil, other, otherCoordinates, comparator, auditWriter)
+}
+
+// matchBinlogCoordinatesUpTo is MatchBinlogCoordinatesWithAudit, where the scan of instance's binary logs may end at
+// given instanceEndCoordinates rather than at the end of the binary logs (see TranslateBinlogCoordinates).
+// A nil instanceEndCoordinates stands for the end of the binary logs.
+func matchBinlogCoordinatesUpTo(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates, instanceEndCoordinates *BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates, comparator EventComparator, auditWriter io.Writer) (*BinlogCoordinatesMatchResult, error) {
+	if instance.Key.Equals(&other.Key) {
+		return nil, log.Errore(ErrSameInstance)
+	}
+	if instanceCoordinates.Type == BinaryLog && instanceEndCoordinates == nil {
+		// The end-of-scan sanity check relies on self coordinates; these must be current. The caller's instance
+		// is left as is.
+		refreshedInstance, err := refreshedSelfBinlogCoordinates(instance)
+		if err != nil {
+			return nil, log.Errore(err)
+		}
+		instance = refreshedInstance
+	}
+
+	fetchNextEvents := retryingBinlogEventsFetcher(&instance.Key, func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+		return getNextBinlogEventsChunk(instance, binlogCoordinates)
+	})
+	fetchOtherNextEvents := retryingBinlogEventsFetcher(&other.Key, func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
 		return getNextBinlogEventsChunk(other, binlogCoordinates)
+	})
+	if config.Config.BinlogMatchRecordingDirectory != "" {
+		recorder, err := newBinlogMatchRecorder(config.Config.BinlogMatchRecordingDirectory, instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, instanceEndCoordinates, other, otherCoordinates)
+		if err != nil {
+			return nil, log.Errore(err)
+		}
+		defer recorder.Close()
+		fetchNextEvents = recorder.recordingFetcher(binlogMatchRecordingInstanceSide, fetchNextEvents)
+		fetchOtherNextEvents = recorder.recordingFetcher(binlogMatchRecordingOtherSide, fetchOtherNextEvents)
+	}
+	return matchBinlogCoordinatesWithFetchers(instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, instanceEndCoordinates, other, otherCoordinates, fetchNextEvents, fetchOtherNextEvents, comparator, auditWriter)
+}
+
+// matchBinlogCoordinatesWithFetchers is the matching algorithm proper, reading events of instance and other via
+// given fetch functions only. Hence the match may be replayed offline (see ReplayBinlogCoordinatesMatch).
+func matchBinlogCoordinatesWithFetchers(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates, instanceEndCoordinates *BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates, fetchNextEvents func(BinlogCoordinates) ([]BinlogEvent, error), fetchOtherNextEvents func(BinlogCoordinates) ([]BinlogEvent, error),
+	comparator EventComparator, auditWriter io.Writer) (*BinlogCoordinatesMatchResult, error) {
+	if comparator == nil {
+		comparator = GetEventComparator()
+	}
+	if config.Config.PseudoGTIDMatchInterchangeableCommitMarkers {
+		comparator = CommitMarkerTolerantEventComparator{Comparator: comparator}
 	}
+	instanceCursor := NewBinlogEventCursor(instanceCoordinates, fetchNextEvents)
 	otherCursor := NewBinlogEventCursor(otherCoordinates, fetchOtherNextEvents)
 
 	var lastConsumedEventCoordinates BinlogCoordinates
 	for {
 		// Exhaust binlogs/relaylogs on instance. While iterating them, also iterate the otherInstance binlogs.
 		// We expect entries on both to match, sequentially, until instance's binlogs/relaylogs are exhausted.
-		var instanceEventInfo string
-		var otherEventInfo string
+		var instanceEvent BinlogEvent
+		var otherEvent BinlogEvent
+		var instanceEventCoordinates BinlogCoordinates
+		var otherEventCoordinates BinlogCoordinates
 		{
 			// Extract next binlog/relaylog entry from instance:
 			event, err := instanceCursor.NextRealEvent()
 			if err != nil {
 				return nil, log.Errore(err)
 			}
+			if event != nil && instanceEndCoordinates != nil && !event.Coordinates.SmallerThan(instanceEndCoordinates) {
+				// Reached given end coordinates; this event is beyond the scan
+				targetMatchCoordinates, targetGTID, err := getMatchTargetCoordinates(&otherCursor, otherCoordinates.Type)
+				if err != nil {
+					return nil, log.Errore(err)
+				}
+				log.Debugf("Reached end coordinates %+v for instance, at %+v. Other coordinates: %+v", *instanceEndCoordinates, event.Coordinates, targetMatchCoordinates)
+				result := &BinlogCoordinatesMatchResult{
+					TargetCoordinates:               targetMatchCoordinates,
+					TargetGTID:                      targetGTID,
+					InstanceLastConsumedCoordinates: lastConsumedEventCoordinates,
+					InstanceEndedAtSelfCoordinates:  event.Coordinates.Equals(instanceEndCoordinates),
+				}
+				return result, nil
+			}
 			if event != nil {
 				lastConsumedEventCoordinates = event.Coordinates
 			}
@@ -271,16 +1849,34 @@ func GetNextBinlogCoordinatesToMatch(instance *Instance, instanceCoordinates Bin
 			case BinaryLog:
 				if event == nil {
 					// end of binary logs for instance:
-					targetMatchCoordinates, err := otherCursor.NextCoordinates()
+					targetMatchCoordinates, targetGTID, err := getMatchTargetCoordinates(&otherCursor, otherCoordinates.Type)
 					if err != nil {
 						return nil, log.Errore(err)
 					}
 					nextCoordinates, _ := instanceCursor.NextCoordinates()
-					if !nextCoordinates.Equals(&instance.SelfBinlogCoordinates) {
-						return nil, log.Errorf("Unexpected problem: instance binlog iteration did not end with current master status. Ended with: %+v, self coordinates: %+v", nextCoordinates, instance.SelfBinlogCoordinates)
+					endedAtSelfCoordinates := nextCoordinates.Equals(&instance.SelfBinlogCoordinates)
+					if instanceEndCoordinates != nil {
+						// The binary logs ended at, or short of, given end coordinates. Self coordinates do not apply
+						endedAtSelfCoordinates = nextCoordinates.Equals(instanceEndCoordinates)
+					} else if !endedAtSelfCoordinates {
+						if selfCoordinatesWithinTolerance(nextCoordinates, instance.SelfBinlogCoordinates) {
+							// A live instance may have written (or we may have read) a few more events since its status was last read
+							log.Warningf("Instance binlog iteration ended near, but not at, current master status. Ended with: %+v, self coordinates: %+v; gap of %d bytes is within BinlogMatchSelfCoordinatesTolerance", nextCoordinates, instance.SelfBinlogCoordinates, nextCoordinates.LogPos-instance.SelfBinlogCoordinates.LogPos)
+						} else if config.Config.SkipMatchSelfCoordinatesCheck {
+							// Escape hatch: the operator prefers a match to a sanity check
+							log.Warningf("Instance binlog iteration did not end with current master status. Ended with: %+v, self coordinates: %+v; ignoring as per SkipMatchSelfCoordinatesCheck", nextCoordinates, instance.SelfBinlogCoordinates)
+						} else {
+							return nil, log.Errorf("Unexpected problem: instance binlog iteration did not end with current master status. Ended with: %+v, self coordinates: %+v", nextCoordinates, instance.SelfBinlogCoordinates)
+						}
 					}
 					log.Debugf("Reached end of binary logs for instance, at %+v. Other coordinates: %+v", nextCoordinates, targetMatchCoordinates)
-					return &targetMatchCoordinates, nil
+					result := &BinlogCoordinatesMatchResult{
+						TargetCoordinates:               targetMatchCoordinates,
+						TargetGTID:                      targetGTID,
+						InstanceLastConsumedCoordinates: lastConsumedEventCoordinates,
+						InstanceEndedAtSelfCoordinates:  endedAtSelfCoordinates,
+					}
+					return result, nil
 				}
 			case RelayLog:
 				// Argghhhh! SHOW RELAY LOG EVENTS IN '...' statement returns CRAPPY values for End_log_pos:
@@ -292,32 +1888,54 @@ func GetNextBinlogCoordinatesToMatch(instance *Instance, instanceCoordinates Bin
 				// last statement, which is SMALLER than Relay_log_pos; and there isn't a "Rotate" entry to make
 				// a place holder or anything. The log just ends and we can't be absolutely certain that the next
 				// statement is indeed (futuristically) as End_log_pos.
+				// There is one exception: a relay log may end with a Rotate event pointing to the master's next
+				// binary log. When such is the case we do know the master coordinates the SQL thread has reached,
+				// and we verify them.
 				endOfScan := false
+				endedAtSelfCoordinates := false
 				if event == nil {
 					// End of relay log...
 					endOfScan = true
 					log.Debugf("Reached end of relay log at %+v", recordedInstanceRelayLogCoordinates)
+					if lastEvent := instanceCursor.LastEvent(); lastEvent != nil && lastEvent.IsRotateToMaster() {
+						rotateCoordinates := lastEvent.RotateCoordinates()
+						if !rotateCoordinates.Equals(&instance.ExecBinlogCoordinates) {
+							return nil, log.Errorf("Unexpected problem: relay log ended with Rotate event to master's %+v, but executed master coordinates are %+v", *rotateCoordinates, instance.ExecBinlogCoordinates)
+						}
+						log.Debugf("Relay log ended with Rotate event to master's %+v, matching executed master coordinates", *rotateCoordinates)
+						endedAtSelfCoordinates = true
+					}
 				} else if recordedInstanceRelayLogCoordinates.Equals(&event.Coordinates) {
 					// We've passed the maxScanInstanceCoordinates (applies for relay logs)
 					endOfScan = true
+					endedAtSelfCoordinates = true
 					log.Debugf("Reached slave relay log coordinates at %+v", recordedInstanceRelayLogCoordinates)
 				} else if recordedInstanceRelayLogCoordinates.SmallerThan(&event.Coordinates) {
 					return nil, log.Errorf("Unexpected problem: relay log scan passed relay log position without hitting it. Ended with: %+v, relay log position: %+v", event.Coordinates, recordedInstanceRelayLogCoordinates)
 				}
 				if endOfScan {
 					// end of binary logs for instance:
-					targetMatchCoordinates, err := otherCursor.NextCoordinates()
+					targetMatchCoordinates, targetGTID, err := getMatchTargetCoordinates(&otherCursor, otherCoordinates.Type)
 					if err != nil {
 						return nil, log.Errore(err)
 					}
 					// No further sanity checks (read the above lengthy explanation)
 					log.Debugf("Reached limit of relay logs for instance, just after %+v. Other coordinates: %+v", lastConsumedEventCoordinates, targetMatchCoordinates)
-					return &targetMatchCoordinates, nil
+					result := &BinlogCoordinatesMatchResult{
+						TargetCoordinates:               targetMatchCoordinates,
+						TargetGTID:                      targetGTID,
+						InstanceLastConsumedCoordinates: lastConsumedEventCoordinates,
+						InstanceEndedAtSelfCoordinates:  endedAtSelfCoordinates,
+					}
+					return result, nil
 				}
 			}
 
-			instanceEventInfo = event.Info
-			log.Debugf("> %+v %+v; %+v", event.Coordinates, event.EventType, event.Info)
+			instanceEvent = *event
+			instanceEventCoordinates = event.Coordinates
+			if config.Config.BinlogMatchTrace {
+				log.Debugf("> %+v %+v; %+v", event.Coordinates, event.EventType, event.Info)
+			}
 		}
 		{
 			// Extract next binlog/relaylog entry from otherInstance (intended master):
@@ -326,19 +1944,65 @@ func GetNextBinlogCoordinatesToMatch(instance *Instance, instanceCoordinates Bin
 				return nil, log.Errore(err)
 			}
 			if event == nil {
-				// end of binary logs for otherInstance: this is unexpected and means instance is more advanced
-				// than otherInstance
+				// end of binary logs (or relay logs) for otherInstance: this is unexpected and means instance is more
+				// advanced than otherInstance
+				if otherCoordinates.Type == RelayLog {
+					return nil, log.Error("Unexpected end of relay logs for assumed master. This means the instance which attempted to be a slave was more advanced. Try the other way round")
+				}
 				return nil, log.Error("Unexpected end of binary logs for assumed master. This means the instance which attempted to be a slave was more advanced. Try the other way round")
 			}
-			otherEventInfo = event.Info
-			log.Debugf("< %+v %+v; %+v", event.Coordinates, event.EventType, event.Info)
+			otherEvent = *event
+			otherEventCoordinates = event.Coordinates
+			if config.Config.BinlogMatchTrace {
+				log.Debugf("< %+v %+v; %+v", event.Coordinates, event.EventType, event.Info)
+			}
 		}
 		// Verify things are sane (the two extracted entries are identical):
 		// (not strictly required by the algorithm but adds such a lovely self-sanity-testing essence)
-		if instanceEventInfo != otherEventInfo {
-			return nil, log.Errorf("Mismatching entries, aborting: %+v <-> %+v", instanceEventInfo, otherEventInfo)
+		eventsMatch := comparator.Equal(instanceEvent, otherEvent)
+		if auditWriter != nil {
+			fmt.Fprintf(auditWriter, "%s:%d\t%s:%d\t%s\n", instanceEventCoordinates.LogFile, instanceEventCoordinates.LogPos,
+				otherEventCoordinates.LogFile, otherEventCoordinates.LogPos, math.TernaryString(eventsMatch, "matched", "mismatched"))
+		}
+		if !eventsMatch && config.Config.PseudoGTIDMatchMismatchLookahead > 0 {
+			// Attempt to re-sync the two streams, assuming either has a few extra events
+			lookahead := config.Config.PseudoGTIDMatchMismatchLookahead
+			event, err := lookaheadForEvent(&otherCursor, instanceEvent, comparator, lookahead, func(event *BinlogEvent) bool { return true })
+			if err != nil {
+				return nil, log.Errore(err)
+			}
+			if event != nil {
+				log.Debugf("Mismatching entries re-synced by skipping other's events up to %+v", event.Coordinates)
+				otherEventCoordinates = event.Coordinates
+			} else {
+				withinScan := func(event *BinlogEvent) bool {
+					if instanceEndCoordinates != nil && !event.Coordinates.SmallerThan(instanceEndCoordinates) {
+						return false
+					}
+					return instanceCoordinates.Type == BinaryLog || event.Coordinates.SmallerThan(&recordedInstanceRelayLogCoordinates)
+				}
+				event, err = lookaheadForEvent(&instanceCursor, otherEvent, comparator, lookahead, withinScan)
+				if err != nil {
+					return nil, log.Errore(err)
+				}
+				if event != nil {
+					log.Debugf("Mismatching entries re-synced by skipping instance's events up to %+v", event.Coordinates)
+					instanceEventCoordinates = event.Coordinates
+					lastConsumedEventCoordinates = event.Coordinates
+				}
+			}
+			if event != nil {
+				eventsMatch = true
+				if auditWriter != nil {
+					fmt.Fprintf(auditWriter, "%s:%d\t%s:%d\t%s\n", instanceEventCoordinates.LogFile, instanceEventCoordinates.LogPos,
+						otherEventCoordinates.LogFile, otherEventCoordinates.LogPos, "resynced")
+				}
+			}
+		}
+		if !eventsMatch {
+			return nil, log.Errorf("Mismatching entries, aborting: %+v <-> %+v", instanceEvent.Info, otherEvent.Info)
 		}
 	}
 
-	return nil, log.Error("GetNextBinlogCoordinatesToMatch: unexpected termination")
+	return nil, log.Error("MatchBinlogCoordinates: unexpected termination")
 }
@@ -0,0 +1,387 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Binary log (v4) format constants. See http://dev.mysql.com/doc/internals/en/binary-log.html
+const (
+	binlogFileHeaderSize        = 4
+	binlogEventHeaderSize       = 19
+	binlogChecksumSize          = 4
+	binlogChecksumAlgCRC32 byte = 1
+
+	queryEventType             byte = 2
+	rotateEventType            byte = 4
+	intvarEventType            byte = 5
+	formatDescriptionEventType byte = 15
+	xidEventType               byte = 16
+	tableMapEventType          byte = 19
+	rowsQueryEventType         byte = 29
+	gtidEventType              byte = 33
+	anonymousGtidEventType     byte = 34
+	previousGtidsEventType     byte = 35
+
+	logEventSuppressUseFlag uint16 = 0x8
+	rowsStmtEndFlag         uint16 = 0x1
+)
+
+var binlogFileMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+// binlogEventTypeNames maps event type codes onto the names presented by SHOW BINLOG EVENTS
+var binlogEventTypeNames = map[byte]string{
+	1:   "Start_v3",
+	2:   "Query",
+	3:   "Stop",
+	4:   "Rotate",
+	5:   "Intvar",
+	6:   "Load",
+	8:   "Create_file",
+	9:   "Append_block",
+	10:  "Exec_load",
+	11:  "Delete_file",
+	12:  "New_load",
+	13:  "RAND",
+	14:  "User var",
+	15:  "Format_desc",
+	16:  "Xid",
+	17:  "Begin_load_query",
+	18:  "Execute_load_query",
+	19:  "Table_map",
+	23:  "Write_rows",
+	24:  "Update_rows",
+	25:  "Delete_rows",
+	26:  "Incident",
+	27:  "Heartbeat",
+	28:  "Ignorable",
+	29:  "Rows_query",
+	30:  "Write_rows",
+	31:  "Update_rows",
+	32:  "Delete_rows",
+	33:  "Gtid",
+	34:  "Anonymous_Gtid",
+	35:  "Previous_gtids",
+	160: "Annotate_rows",
+	161: "Binlog_checkpoint",
+	162: "Gtid",
+	163: "Gtid_list",
+}
+
+// rowsEventTypes are the row based replication events: WRITE/UPDATE/DELETE, v1 and v2
+var rowsEventTypes = map[byte]bool{23: true, 24: true, 25: true, 30: true, 31: true, 32: true}
+
+// binlogEventHeader is the common header of all binary log events
+type binlogEventHeader struct {
+	Timestamp    uint32
+	EventType    byte
+	ServerId     uint32
+	EventSize    uint32
+	NextPosition uint32
+	Flags        uint16
+}
+
+// binlogFileFormat describes the format of a specific binary log file, as read from its Format_desc event
+type binlogFileFormat struct {
+	binlogVersion     uint16
+	serverVersion     string
+	postHeaderLengths []byte
+	checksumAlg       byte
+}
+
+// postHeaderLength returns the length of the post-header of given event type
+func (this *binlogFileFormat) postHeaderLength(eventType byte, defaultLength int) int {
+	if int(eventType) <= len(this.postHeaderLengths) && eventType > 0 {
+		return int(this.postHeaderLengths[eventType-1])
+	}
+	return defaultLength
+}
+
+// FileBinlogReader is a BinlogReader which reads binary logs or relay logs directly off local files.
+// This is useful when orchestrator runs co-located with a server (or a mirror) holding the logs, avoiding
+// the network and server side overhead of SHOW BINLOG EVENTS.
+// Event Info is presented in the same form as SHOW BINLOG EVENTS for those events which matter to
+// Pseudo-GTID matching (Query, Xid, Rotate, Table_map, rows events, GTID events). Other events get an empty Info.
+type FileBinlogReader struct {
+	directory string
+}
+
+// NewFileBinlogReader creates a reader of binary/relay log files found in given directory
+func NewFileBinlogReader(directory string) *FileBinlogReader {
+	return &FileBinlogReader{directory: directory}
+}
+
+// ReadEvents implements BinlogReader. The instanceKey is only used for reporting.
+func (this *FileBinlogReader) ReadEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, onEvent func(event BinlogEvent) error) error {
+	file, err := os.Open(filepath.Join(this.directory, startingCoordinates.LogFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic := make([]byte, binlogFileHeaderSize)
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, binlogFileMagic) {
+		return errors.New(fmt.Sprintf("Not a binary log file: %s of %+v", startingCoordinates.LogFile, *instanceKey))
+	}
+	// The Format_desc event is always the first event; we must read it to be able to parse any other event
+	header, body, err := readBinlogFileEvent(reader)
+	if err != nil {
+		return err
+	}
+	if header.EventType != formatDescriptionEventType {
+		return errors.New(fmt.Sprintf("Expected Format_desc event at beginning of %s of %+v", startingCoordinates.LogFile, *instanceKey))
+	}
+	format := parseBinlogFileFormat(body)
+
+	position := int64(binlogFileHeaderSize)
+	if startingCoordinates.LogPos > position {
+		if _, err := file.Seek(startingCoordinates.LogPos, 0); err != nil {
+			return err
+		}
+		reader.Reset(file)
+		position = startingCoordinates.LogPos
+	} else {
+		// Report the Format_desc event we've just read
+		if limit <= 0 {
+			return nil
+		}
+		event := newBinlogFileEvent(startingCoordinates, position, header, body, format)
+		if err := onEvent(event); err != nil {
+			return err
+		}
+		limit--
+		position += int64(header.EventSize)
+	}
+	for ; limit > 0; limit-- {
+		header, body, err := readBinlogFileEvent(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// End of file, or a trailing event which is still being written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if format.checksumAlg == binlogChecksumAlgCRC32 && len(body) >= binlogChecksumSize {
+			body = body[:len(body)-binlogChecksumSize]
+		}
+		event := newBinlogFileEvent(startingCoordinates, position, header, body, format)
+		if err := onEvent(event); err != nil {
+			return err
+		}
+		position += int64(header.EventSize)
+	}
+	return nil
+}
+
+// readBinlogFileEvent reads the next event off a binary log, returning its header and body.
+func readBinlogFileEvent(reader io.Reader) (*binlogEventHeader, []byte, error) {
+	headerBytes := make([]byte, binlogEventHeaderSize)
+	if _, err := io.ReadFull(reader, headerBytes); err != nil {
+		return nil, nil, err
+	}
+	header := &binlogEventHeader{
+		Timestamp:    binary.LittleEndian.Uint32(headerBytes[0:]),
+		EventType:    headerBytes[4],
+		ServerId:     binary.LittleEndian.Uint32(headerBytes[5:]),
+		EventSize:    binary.LittleEndian.Uint32(headerBytes[9:]),
+		NextPosition: binary.LittleEndian.Uint32(headerBytes[13:]),
+		Flags:        binary.LittleEndian.Uint16(headerBytes[17:]),
+	}
+	if header.EventSize < binlogEventHeaderSize {
+		return nil, nil, errors.New(fmt.Sprintf("Invalid binlog event size: %d", header.EventSize))
+	}
+	body := make([]byte, header.EventSize-binlogEventHeaderSize)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	return header, body, nil
+}
+
+var serverVersionNumbersRegexp = regexp.MustCompile(`^([0-9]+)[.]([0-9]+)[.]([0-9]+)`)
+
+// serverVersionHasChecksumAlg returns true when binary logs written by given server version carry the checksum
+// algorithm in the Format_desc event (MySQL 5.6.1 and above, MariaDB 5.3 and above)
+func serverVersionHasChecksumAlg(serverVersion string) bool {
+	submatch := serverVersionNumbersRegexp.FindStringSubmatch(serverVersion)
+	if submatch == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(submatch[1])
+	minor, _ := strconv.Atoi(submatch[2])
+	patch, _ := strconv.Atoi(submatch[3])
+	versionProduct := major*10000 + minor*100 + patch
+	if strings.Contains(strings.ToLower(serverVersion), "mariadb") {
+		return versionProduct >= 50300
+	}
+	return versionProduct >= 50601
+}
+
+// parseBinlogFileFormat parses the body of a Format_desc event
+func parseBinlogFileFormat(body []byte) *binlogFileFormat {
+	format := &binlogFileFormat{}
+	if len(body) < 57 {
+		return format
+	}
+	format.binlogVersion = binary.LittleEndian.Uint16(body[0:])
+	format.serverVersion = string(bytes.TrimRight(body[2:52], "\x00"))
+	// body[52:56] is create timestamp, body[56] is common header length
+	postHeaderLengths := body[57:]
+	if serverVersionHasChecksumAlg(format.serverVersion) && len(postHeaderLengths) >= 1+binlogChecksumSize {
+		format.checksumAlg = postHeaderLengths[len(postHeaderLengths)-binlogChecksumSize-1]
+		postHeaderLengths = postHeaderLengths[:len(postHeaderLengths)-binlogChecksumSize-1]
+	}
+	format.postHeaderLengths = postHeaderLengths
+	return format
+}
+
+// newBinlogFileEvent creates a BinlogEvent out of a parsed event
+func newBinlogFileEvent(startingCoordinates BinlogCoordinates, position int64, header *binlogEventHeader, body []byte, format *binlogFileFormat) BinlogEvent {
+	event := BinlogEvent{}
+	event.Coordinates.LogFile = startingCoordinates.LogFile
+	event.Coordinates.LogPos = position
+	event.Coordinates.Type = startingCoordinates.Type
+	event.NextEventPos = int64(header.NextPosition)
+	event.EventType = binlogEventTypeNames[header.EventType]
+	event.Info = binlogFileEventInfo(header, body, format)
+	event.Timestamp = time.Unix(int64(header.Timestamp), 0)
+	event.ServerId = uint(header.ServerId)
+	event.Flags = header.Flags
+	event.normalizeNextEventPos()
+	return event
+}
+
+// readBinlogTableId reads a table id, which is either 4 or 6 bytes long, depending on post header length
+func readBinlogTableId(body []byte, postHeaderLength int) uint64 {
+	if postHeaderLength == 6 {
+		return uint64(binary.LittleEndian.Uint32(body))
+	}
+	tableIdBytes := make([]byte, 8)
+	copy(tableIdBytes, body[0:6])
+	return binary.LittleEndian.Uint64(tableIdBytes)
+}
+
+// formatBinlogUUID formats a 16 byte server UUID
+func formatBinlogUUID(sid []byte) string {
+	h := hex.EncodeToString(sid)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+// binlogFileEventInfo computes an event's Info, in the form presented by SHOW BINLOG EVENTS.
+// Malformed (truncated) events are presented with empty Info.
+func binlogFileEventInfo(header *binlogEventHeader, body []byte, format *binlogFileFormat) (info string) {
+	defer func() {
+		if recover() != nil {
+			info = ""
+		}
+	}()
+	switch header.EventType {
+	case queryEventType:
+		postHeaderLength := format.postHeaderLength(queryEventType, 13)
+		dbLength := int(body[8])
+		statusVarsLength := int(binary.LittleEndian.Uint16(body[11:]))
+		dbStart := postHeaderLength + statusVarsLength
+		schema := string(body[dbStart : dbStart+dbLength])
+		query := string(body[dbStart+dbLength+1:])
+		if schema != "" && header.Flags&logEventSuppressUseFlag == 0 {
+			return fmt.Sprintf("use `%s`; %s", schema, query)
+		}
+		return query
+	case rotateEventType:
+		postHeaderLength := format.postHeaderLength(rotateEventType, 8)
+		return fmt.Sprintf("%s;pos=%d", string(body[postHeaderLength:]), binary.LittleEndian.Uint64(body))
+	case intvarEventType:
+		variable := "INSERT_ID"
+		if body[0] == 1 {
+			variable = "LAST_INSERT_ID"
+		}
+		return fmt.Sprintf("%s=%d", variable, binary.LittleEndian.Uint64(body[1:]))
+	case formatDescriptionEventType:
+		return fmt.Sprintf("Server ver: %s, Binlog ver: %d", format.serverVersion, format.binlogVersion)
+	case xidEventType:
+		return fmt.Sprintf("COMMIT /* xid=%d */", binary.LittleEndian.Uint64(body))
+	case tableMapEventType:
+		postHeaderLength := format.postHeaderLength(tableMapEventType, 8)
+		tableId := readBinlogTableId(body, postHeaderLength)
+		schemaLength := int(body[postHeaderLength])
+		schema := string(body[postHeaderLength+1 : postHeaderLength+1+schemaLength])
+		tableStart := postHeaderLength + 1 + schemaLength + 1
+		tableLength := int(body[tableStart])
+		table := string(body[tableStart+1 : tableStart+1+tableLength])
+		return fmt.Sprintf("table_id: %d (%s.%s)", tableId, schema, table)
+	case rowsQueryEventType:
+		return fmt.Sprintf("# %s", string(body[1:]))
+	case gtidEventType:
+		return fmt.Sprintf("SET @@SESSION.GTID_NEXT= '%s:%d'", formatBinlogUUID(body[1:17]), binary.LittleEndian.Uint64(body[17:]))
+	case anonymousGtidEventType:
+		return "SET @@SESSION.GTID_NEXT= 'ANONYMOUS'"
+	case previousGtidsEventType:
+		numSids := int(binary.LittleEndian.Uint64(body))
+		offset := 8
+		gtidSets := []string{}
+		for i := 0; i < numSids; i++ {
+			tokens := []string{formatBinlogUUID(body[offset : offset+16])}
+			numIntervals := int(binary.LittleEndian.Uint64(body[offset+16:]))
+			offset += 24
+			for j := 0; j < numIntervals; j++ {
+				start := binary.LittleEndian.Uint64(body[offset:])
+				end := binary.LittleEndian.Uint64(body[offset+8:]) - 1
+				offset += 16
+				if start == end {
+					tokens = append(tokens, fmt.Sprintf("%d", start))
+				} else {
+					tokens = append(tokens, fmt.Sprintf("%d-%d", start, end))
+				}
+			}
+			gtidSets = append(gtidSets, strings.Join(tokens, ":"))
+		}
+		return strings.Join(gtidSets, ",\n")
+	}
+	if rowsEventTypes[header.EventType] {
+		postHeaderLength := format.postHeaderLength(header.EventType, 8)
+		tableId := readBinlogTableId(body, postHeaderLength)
+		tableIdLength := 6
+		if postHeaderLength == 6 {
+			tableIdLength = 4
+		}
+		flags := binary.LittleEndian.Uint16(body[tableIdLength:])
+		if flags&rowsStmtEndFlag != 0 {
+			return fmt.Sprintf("table_id: %d flags: STMT_END_F", tableId)
+		}
+		return fmt.Sprintf("table_id: %d", tableId)
+	}
+	return ""
+}
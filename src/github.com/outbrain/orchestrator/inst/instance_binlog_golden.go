@@ -0,0 +1,126 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A golden stream is a recording of a binlog event stream, as seen by BinlogEventCursor.NextRealEvent(): one line
+// per event, holding the event type and the (normalized, quoted) event info, tab separated.
+// Golden streams are used for testing Pseudo-GTID injection tooling: a live instance is compared against a known
+// good recording.
+
+// GoldenStreamDivergence describes the first difference between a live event stream and a golden stream
+type GoldenStreamDivergence struct {
+	EventIndex        int
+	Coordinates       BinlogCoordinates
+	ExpectedEventType string
+	ExpectedInfo      string
+	ActualEventType   string
+	ActualInfo        string
+}
+
+// WriteGoldenStream records up to limit events read off given cursor onto given writer. A non-positive limit
+// records up to the end of the stream. It returns the number of events recorded.
+func WriteGoldenStream(cursor *BinlogEventCursor, writer io.Writer, limit int) (int, error) {
+	count := 0
+	for limit <= 0 || count < limit {
+		event, err := cursor.NextRealEvent()
+		if err != nil {
+			return count, err
+		}
+		if event == nil {
+			break
+		}
+		if _, err := fmt.Fprintf(writer, "%s\t%s\n", event.EventType, strconv.Quote(event.Info)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// CompareToGoldenStreamReader compares events read off given cursor against the golden stream read from given
+// reader, and returns the first divergence; nil when all golden events are matched. The live stream may extend
+// beyond the golden stream.
+func CompareToGoldenStreamReader(cursor *BinlogEventCursor, reader io.Reader) (*GoldenStreamDivergence, error) {
+	scanner := bufio.NewScanner(reader)
+	for eventIndex := 0; scanner.Scan(); eventIndex++ {
+		tokens := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(tokens) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid golden stream line %d: %s", eventIndex+1, scanner.Text()))
+		}
+		expectedInfo, err := strconv.Unquote(tokens[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid golden stream line %d: %s", eventIndex+1, scanner.Text()))
+		}
+		divergence := &GoldenStreamDivergence{EventIndex: eventIndex, ExpectedEventType: tokens[0], ExpectedInfo: expectedInfo}
+
+		event, err := cursor.NextRealEvent()
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			// Live stream ended prematurely
+			return divergence, nil
+		}
+		divergence.Coordinates = event.Coordinates
+		divergence.ActualEventType = event.EventType
+		divergence.ActualInfo = event.Info
+		if event.EventType != divergence.ExpectedEventType || !EventInfoEquals(event.Info, divergence.ExpectedInfo) {
+			return divergence, nil
+		}
+	}
+	return nil, scanner.Err()
+}
+
+// RecordGoldenStream records the binary log event stream of given instance, starting at given coordinates,
+// onto given file. See WriteGoldenStream.
+func RecordGoldenStream(instance *Instance, startCoordinates BinlogCoordinates, goldenFile string, limit int) (int, error) {
+	file, err := os.Create(goldenFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	cursor := NewBinlogEventCursor(startCoordinates, func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+		return getNextBinlogEventsChunk(instance, binlogCoordinates)
+	})
+	return WriteGoldenStream(&cursor, file, limit)
+}
+
+// CompareToGoldenStream compares the binary log event stream of given instance, starting at given coordinates,
+// against the golden stream recorded in given file. See CompareToGoldenStreamReader.
+func CompareToGoldenStream(instance *Instance, startCoordinates BinlogCoordinates, goldenFile string) (*GoldenStreamDivergence, error) {
+	file, err := os.Open(goldenFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cursor := NewBinlogEventCursor(startCoordinates, func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+		return getNextBinlogEventsChunk(instance, binlogCoordinates)
+	})
+	return CompareToGoldenStreamReader(&cursor, file)
+}
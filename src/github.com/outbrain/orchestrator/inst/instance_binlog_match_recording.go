@@ -0,0 +1,165 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/outbrain/golib/log"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// A match recording holds everything a binlog coordinates match has read off the two instances: a header, followed
+// by a record per fetched chunk of events, one JSON document per line. Replaying a recording feeds these chunks back
+// through the matcher, reproducing a match without the live servers.
+
+const (
+	binlogMatchRecordingInstanceSide = "instance"
+	binlogMatchRecordingOtherSide    = "other"
+)
+
+// BinlogMatchRecordingHeader describes the match recorded: the instances' state and the coordinates matched
+type BinlogMatchRecordingHeader struct {
+	InstanceKey                         InstanceKey
+	InstanceSelfBinlogCoordinates       BinlogCoordinates
+	InstanceExecBinlogCoordinates       BinlogCoordinates
+	InstanceCoordinates                 BinlogCoordinates
+	RecordedInstanceRelayLogCoordinates BinlogCoordinates
+	InstanceEndCoordinates              *BinlogCoordinates
+	OtherKey                            InstanceKey
+	OtherCoordinates                    BinlogCoordinates
+}
+
+// BinlogMatchRecordingChunk is the outcome of a single fetch of events, by either side of the match
+type BinlogMatchRecordingChunk struct {
+	Side        string
+	Coordinates BinlogCoordinates
+	Events      []BinlogEvent
+	Error       string
+}
+
+// binlogMatchRecorder writes a match recording onto a file
+type binlogMatchRecorder struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// newBinlogMatchRecorder creates a recording file in given directory and writes the match header onto it
+func newBinlogMatchRecorder(directory string, instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates, instanceEndCoordinates *BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates) (*binlogMatchRecorder, error) {
+	fileName := path.Join(directory, fmt.Sprintf("match-%s-%s-%d.json", instance.Key.DisplayString(), other.Key.DisplayString(), time.Now().UnixNano()))
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &binlogMatchRecorder{file: file, encoder: json.NewEncoder(file)}
+	header := BinlogMatchRecordingHeader{
+		InstanceKey:                         instance.Key,
+		InstanceSelfBinlogCoordinates:       instance.SelfBinlogCoordinates,
+		InstanceExecBinlogCoordinates:       instance.ExecBinlogCoordinates,
+		InstanceCoordinates:                 instanceCoordinates,
+		RecordedInstanceRelayLogCoordinates: recordedInstanceRelayLogCoordinates,
+		InstanceEndCoordinates:              instanceEndCoordinates,
+		OtherKey:                            other.Key,
+		OtherCoordinates:                    otherCoordinates,
+	}
+	if err := recorder.encoder.Encode(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.Debugf("Recording match of %+v below %+v onto %s", instance.Key, other.Key, fileName)
+	return recorder, nil
+}
+
+// recordingFetcher wraps a fetch function such that each fetch is recorded. A failure to record is logged, but
+// does not fail the match.
+func (this *binlogMatchRecorder) recordingFetcher(side string, fetch func(BinlogCoordinates) ([]BinlogEvent, error)) func(BinlogCoordinates) ([]BinlogEvent, error) {
+	return func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+		events, err := fetch(binlogCoordinates)
+		chunk := BinlogMatchRecordingChunk{Side: side, Coordinates: binlogCoordinates, Events: events}
+		if err != nil {
+			chunk.Error = err.Error()
+		}
+		if recordErr := this.encoder.Encode(chunk); recordErr != nil {
+			log.Errore(recordErr)
+		}
+		return events, err
+	}
+}
+
+func (this *binlogMatchRecorder) Close() error {
+	return this.file.Close()
+}
+
+// ReplayBinlogCoordinatesMatchReader replays the match recorded in given reader: it feeds the recorded chunks back
+// through the matcher, and returns the match's outcome. A fetch which was not recorded fails the replay, which
+// typically means the matcher (or its configuration) behaves differently than it did when recording.
+func ReplayBinlogCoordinatesMatchReader(reader io.Reader, comparator EventComparator) (*BinlogCoordinatesMatchResult, error) {
+	decoder := json.NewDecoder(reader)
+	header := BinlogMatchRecordingHeader{}
+	if err := decoder.Decode(&header); err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot read match recording header: %+v", err))
+	}
+	chunks := make(map[string]BinlogMatchRecordingChunk)
+	for {
+		chunk := BinlogMatchRecordingChunk{}
+		err := decoder.Decode(&chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Cannot read match recording chunk: %+v", err))
+		}
+		chunks[fmt.Sprintf("%s %+v", chunk.Side, chunk.Coordinates)] = chunk
+	}
+	replayingFetcher := func(side string) func(BinlogCoordinates) ([]BinlogEvent, error) {
+		return func(binlogCoordinates BinlogCoordinates) ([]BinlogEvent, error) {
+			chunk, found := chunks[fmt.Sprintf("%s %+v", side, binlogCoordinates)]
+			if !found {
+				return nil, errors.New(fmt.Sprintf("Match recording holds no %s chunk at %+v", side, binlogCoordinates))
+			}
+			if chunk.Error != "" {
+				return chunk.Events, errors.New(chunk.Error)
+			}
+			return chunk.Events, nil
+		}
+	}
+
+	instance := NewInstance()
+	instance.Key = header.InstanceKey
+	instance.SelfBinlogCoordinates = header.InstanceSelfBinlogCoordinates
+	instance.ExecBinlogCoordinates = header.InstanceExecBinlogCoordinates
+	other := NewInstance()
+	other.Key = header.OtherKey
+	return matchBinlogCoordinatesWithFetchers(instance, header.InstanceCoordinates, header.RecordedInstanceRelayLogCoordinates, header.InstanceEndCoordinates, other, header.OtherCoordinates,
+		replayingFetcher(binlogMatchRecordingInstanceSide), replayingFetcher(binlogMatchRecordingOtherSide), comparator, nil)
+}
+
+// ReplayBinlogCoordinatesMatch replays the match recorded in given file. See ReplayBinlogCoordinatesMatchReader.
+func ReplayBinlogCoordinatesMatch(recordingFile string, comparator EventComparator) (*BinlogCoordinatesMatchResult, error) {
+	file, err := os.Open(recordingFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReplayBinlogCoordinatesMatchReader(file, comparator)
+}
@@ -0,0 +1,325 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	mysqlbinlogPositionRegexp  = regexp.MustCompile(`^# at ([0-9]+)$`)
+	mysqlbinlogHeaderRegexp    = regexp.MustCompile(`^#([0-9]{6}\s+[0-9]{1,2}:[0-9]{2}:[0-9]{2})\s+server id\s+([0-9]+)\s+end_log_pos\s+([0-9]+)\s+(?:CRC32 0x[0-9a-fA-F]+\s+)?(.*)$`)
+	mysqlbinlogStartRegexp     = regexp.MustCompile(`^Start: binlog v ([0-9]+), server v ([^ ]+)`)
+	mysqlbinlogRotateRegexp    = regexp.MustCompile(`^Rotate to (.+?)\s+pos: ([0-9]+)`)
+	mysqlbinlogXidRegexp       = regexp.MustCompile(`^Xid = ([0-9]+)`)
+	mysqlbinlogTableMapRegexp  = regexp.MustCompile("^Table_map: `(.*)`\\.`(.*)` mapped to number ([0-9]+)")
+	mysqlbinlogRowsEventRegexp = regexp.MustCompile(`^([A-Za-z_]+_rows)(?:_v1)?: table id ([0-9]+)(.*)$`)
+	mysqlbinlogUseRegexp       = regexp.MustCompile("^use `(.*)`$")
+)
+
+// mysqlbinlogTimestampLayout is the layout of event timestamps in mysqlbinlog's output, e.g. "150725 9:34:56"
+const mysqlbinlogTimestampLayout = "060102 15:04:05"
+
+// statements emitted by mysqlbinlog for the purpose of replaying events, which are not part of the event's info
+var mysqlbinlogSessionStatementPrefixes = []string{"SET TIMESTAMP=", "SET @@session.", "/*!\\C", "use ", "SET @@SESSION.GTID_NEXT"}
+
+// errMysqlbinlogLimitReached is used internally to stop parsing once enough events have been read
+var errMysqlbinlogLimitReached = errors.New("mysqlbinlog read limit reached")
+
+// MysqlbinlogBinlogReader is a BinlogReader which enumerates binary log events by running
+// `mysqlbinlog --read-from-remote-server` and parsing its output, rather than via SHOW BINLOG EVENTS, which holds
+// server side resources throughout the scan. mysqlbinlog can only read binary logs; relay logs are read via SQL.
+// The events' info is emulated to resemble that of SHOW BINLOG EVENTS, as far as Pseudo-GTID matching is concerned;
+// all instances in a match should be read by the same kind of reader.
+type MysqlbinlogBinlogReader struct {
+	command string
+}
+
+// NewMysqlbinlogBinlogReader creates a reader running given mysqlbinlog command (e.g. "mysqlbinlog", or a full path)
+func NewMysqlbinlogBinlogReader(command string) *MysqlbinlogBinlogReader {
+	return &MysqlbinlogBinlogReader{command: command}
+}
+
+func (this *MysqlbinlogBinlogReader) ReadEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, onEvent func(event BinlogEvent) error) error {
+	return this.ReadEventsOfTypes(instanceKey, startingCoordinates, limit, nil, onEvent)
+}
+
+// ReadEventsOfTypes reads events as ReadEvents does, skipping the decoding of events of types not in eventTypes.
+// mysqlbinlog transfers the entire log regardless; we spare the parsing of bodies we would only throw away.
+func (this *MysqlbinlogBinlogReader) ReadEventsOfTypes(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, eventTypes map[string]bool, onEvent func(event BinlogEvent) error) error {
+	if startingCoordinates.Type == RelayLog {
+		return defaultBinlogReader.ReadEvents(instanceKey, startingCoordinates, limit, onEvent)
+	}
+	user, password := getBinlogScanCredentials(instanceKey)
+	args := []string{
+		"--read-from-remote-server",
+		fmt.Sprintf("--host=%s", instanceKey.Hostname),
+		fmt.Sprintf("--port=%d", instanceKey.Port),
+		fmt.Sprintf("--user=%s", user),
+		"--base64-output=DECODE-ROWS",
+	}
+	if startingCoordinates.LogPos > 0 {
+		args = append(args, fmt.Sprintf("--start-position=%d", startingCoordinates.LogPos))
+	}
+	args = append(args, startingCoordinates.LogFile)
+
+	cmd := exec.Command(this.command, args...)
+	// Passing the password via environment keeps it off the process list
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", password))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	eventsRead := 0
+	err = ParseMysqlbinlogOutputOfTypes(stdout, startingCoordinates.LogFile, eventTypes, func(event BinlogEvent) error {
+		if event.Coordinates.LogPos < startingCoordinates.LogPos {
+			// With --start-position, mysqlbinlog still prints the log's Format_desc event, which it needs for decoding
+			return nil
+		}
+		if eventsRead >= limit {
+			return errMysqlbinlogLimitReached
+		}
+		eventsRead++
+		return onEvent(event)
+	})
+	if err != nil {
+		// We may have stopped reading before mysqlbinlog is done
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err == errMysqlbinlogLimitReached {
+			return nil
+		}
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return errors.New(fmt.Sprintf("%s failed on %+v: %+v; %s", this.command, *instanceKey, err, strings.TrimSpace(stderr.String())))
+	}
+	return nil
+}
+
+// ParseMysqlbinlogOutput parses the textual output of mysqlbinlog (run with --base64-output=DECODE-ROWS) over given
+// binary log, calling onEvent for each event found, in order. If onEvent returns with error, parsing stops and that
+// error is returned.
+func ParseMysqlbinlogOutput(reader io.Reader, logFile string, onEvent func(event BinlogEvent) error) error {
+	return ParseMysqlbinlogOutputOfTypes(reader, logFile, nil, onEvent)
+}
+
+// ParseMysqlbinlogOutputOfTypes parses mysqlbinlog output as ParseMysqlbinlogOutput does. Events of types not in
+// eventTypes are still delivered, with their type and coordinates, but with an empty Info. A nil eventTypes
+// stands for all events.
+func ParseMysqlbinlogOutputOfTypes(reader io.Reader, logFile string, eventTypes map[string]bool, onEvent func(event BinlogEvent) error) error {
+	bufferedReader := bufio.NewReader(reader)
+	var event *BinlogEvent
+	var description string
+	bodyLines := []string{}
+	skipBody := false
+	// mysqlbinlog only prints the default database when it changes, hence we track it throughout the log
+	currentDatabase := ""
+
+	emitEvent := func() error {
+		if event == nil {
+			return nil
+		}
+		applyMysqlbinlogDescription(event, description, bodyLines, &currentDatabase)
+		if skipBody {
+			event.Info = ""
+		}
+		event.normalizeNextEventPos()
+		currentEvent := *event
+		event = nil
+		description = ""
+		bodyLines = []string{}
+		skipBody = false
+		return onEvent(currentEvent)
+	}
+	for {
+		line, readErr := bufferedReader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if submatch := mysqlbinlogPositionRegexp.FindStringSubmatch(line); submatch != nil {
+			if err := emitEvent(); err != nil {
+				return err
+			}
+			logPos, _ := strconv.ParseInt(submatch[1], 10, 64)
+			event = &BinlogEvent{Coordinates: BinlogCoordinates{LogFile: logFile, LogPos: logPos, Type: BinaryLog}}
+		} else if strings.HasPrefix(line, "# End of log file") {
+			// Whatever follows is mysqlbinlog's own epilogue
+			return emitEvent()
+		} else if event != nil {
+			if submatch := mysqlbinlogHeaderRegexp.FindStringSubmatch(line); submatch != nil && description == "" {
+				// mysqlbinlog prints timestamps in its local time zone
+				event.Timestamp, _ = time.ParseInLocation(mysqlbinlogTimestampLayout, strings.Join(strings.Fields(submatch[1]), " "), time.Local)
+				serverId, _ := strconv.ParseUint(submatch[2], 10, 32)
+				event.ServerId = uint(serverId)
+				event.NextEventPos, _ = strconv.ParseInt(submatch[3], 10, 64)
+				description = strings.TrimSpace(submatch[4])
+				if eventTypes != nil {
+					skipBody = !eventTypes[mysqlbinlogEventType(description)]
+				}
+			} else if !skipBody {
+				bodyLines = append(bodyLines, line)
+			}
+		}
+		if readErr == io.EOF {
+			return emitEvent()
+		}
+	}
+}
+
+// mysqlbinlogStatements splits an event's body into its statements, each terminated by mysqlbinlog's delimiter
+func mysqlbinlogStatements(bodyLines []string) []string {
+	statements := []string{}
+	statementLines := []string{}
+	for _, line := range bodyLines {
+		if strings.HasPrefix(line, "#") && len(statementLines) == 0 {
+			continue
+		}
+		if strings.HasSuffix(line, "/*!*/;") {
+			statementLines = append(statementLines, strings.TrimSuffix(line, "/*!*/;"))
+			statements = append(statements, strings.TrimSpace(strings.Join(statementLines, "\n")))
+			statementLines = []string{}
+			continue
+		}
+		statementLines = append(statementLines, line)
+	}
+	return statements
+}
+
+// mysqlbinlogEventStatement returns the event's own statement, skipping mysqlbinlog's session statements
+func mysqlbinlogEventStatement(statements []string) string {
+	eventStatement := ""
+	for _, statement := range statements {
+		isSessionStatement := false
+		for _, prefix := range mysqlbinlogSessionStatementPrefixes {
+			if strings.HasPrefix(statement, prefix) {
+				isSessionStatement = true
+			}
+		}
+		if !isSessionStatement {
+			eventStatement = statement
+		}
+	}
+	return eventStatement
+}
+
+// mysqlbinlogCommentLines returns the comment lines of an event's body, which is where mysqlbinlog prints some
+// events' payload (e.g. Previous-GTIDs, Rows_query)
+func mysqlbinlogCommentLines(bodyLines []string) []string {
+	commentLines := []string{}
+	for _, line := range bodyLines {
+		if strings.HasPrefix(line, "#") {
+			commentLines = append(commentLines, line)
+		}
+	}
+	return commentLines
+}
+
+// mysqlbinlogEventType returns the type of event described by given mysqlbinlog event description
+func mysqlbinlogEventType(description string) string {
+	event := BinlogEvent{}
+	currentDatabase := ""
+	applyMysqlbinlogDescription(&event, description, nil, &currentDatabase)
+	return event.EventType
+}
+
+// applyMysqlbinlogDescription sets the event type and info of given event, based on mysqlbinlog's event
+// description and body, in the likeness of SHOW BINLOG EVENTS
+func applyMysqlbinlogDescription(event *BinlogEvent, description string, bodyLines []string, currentDatabase *string) {
+	if submatch := mysqlbinlogStartRegexp.FindStringSubmatch(description); submatch != nil {
+		event.EventType = "Format_desc"
+		event.Info = fmt.Sprintf("Server ver: %s, Binlog ver: %s", submatch[2], submatch[1])
+		return
+	}
+	if submatch := mysqlbinlogRotateRegexp.FindStringSubmatch(description); submatch != nil {
+		event.EventType = "Rotate"
+		event.Info = fmt.Sprintf("%s;pos=%s", submatch[1], submatch[2])
+		return
+	}
+	if submatch := mysqlbinlogXidRegexp.FindStringSubmatch(description); submatch != nil {
+		event.EventType = "Xid"
+		event.Info = fmt.Sprintf("COMMIT /* xid=%s */", submatch[1])
+		return
+	}
+	if submatch := mysqlbinlogTableMapRegexp.FindStringSubmatch(description); submatch != nil {
+		event.EventType = "Table_map"
+		event.Info = fmt.Sprintf("table_id: %s (%s.%s)", submatch[3], submatch[1], submatch[2])
+		return
+	}
+	if submatch := mysqlbinlogRowsEventRegexp.FindStringSubmatch(description); submatch != nil {
+		event.EventType = submatch[1]
+		event.Info = fmt.Sprintf("table_id: %s%s", submatch[2], submatch[3])
+		return
+	}
+	descriptionTokens := strings.Fields(description)
+	if len(descriptionTokens) == 0 {
+		event.EventType = "Unknown"
+		return
+	}
+	switch descriptionTokens[0] {
+	case "Query":
+		event.EventType = "Query"
+		statements := mysqlbinlogStatements(bodyLines)
+		for _, statement := range statements {
+			if submatch := mysqlbinlogUseRegexp.FindStringSubmatch(statement); submatch != nil {
+				*currentDatabase = submatch[1]
+			}
+		}
+		event.Info = mysqlbinlogEventStatement(statements)
+		if *currentDatabase != "" && event.Info != "BEGIN" && event.Info != "COMMIT" {
+			event.Info = fmt.Sprintf("use `%s`; %s", *currentDatabase, event.Info)
+		}
+	case "Intvar":
+		event.EventType = "Intvar"
+		event.Info = strings.TrimPrefix(mysqlbinlogEventStatement(mysqlbinlogStatements(bodyLines)), "SET ")
+	case "GTID":
+		event.EventType = "Gtid"
+		for _, statement := range mysqlbinlogStatements(bodyLines) {
+			if strings.HasPrefix(statement, "SET @@SESSION.GTID_NEXT") {
+				event.Info = statement
+			}
+		}
+	case "Anonymous_GTID":
+		event.EventType = "Anonymous_Gtid"
+		event.Info = "SET @@SESSION.GTID_NEXT= 'ANONYMOUS'"
+	case "Previous-GTIDs":
+		event.EventType = "Previous_gtids"
+		event.Info = strings.TrimSpace(strings.TrimPrefix(strings.Join(mysqlbinlogCommentLines(bodyLines), ""), "#"))
+	case "Rows_query":
+		event.EventType = "Rows_query"
+		event.Info = strings.Join(mysqlbinlogCommentLines(bodyLines), "\n")
+	default:
+		event.EventType = strings.TrimSuffix(descriptionTokens[0], ":")
+	}
+}
@@ -0,0 +1,578 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/golib/math"
+	"github.com/outbrain/golib/sqlutils"
+	"github.com/outbrain/orchestrator/config"
+	"github.com/pmylund/go-cache"
+	"time"
+)
+
+// errBinlogScanTerminated is used internally by scanning callbacks to indicate they are satisfied and
+// the scan should not proceed. It is never returned to callers of the scan functions.
+var errBinlogScanTerminated = errors.New("Binlog scan terminated")
+
+// ErrRelayLogEventsUnsupported is returned when reading relay logs of a server which does not support
+// SHOW RELAYLOG EVENTS, and whose relay logs are not otherwise available (see RelayLogFilesDirectories)
+var ErrRelayLogEventsUnsupported = errors.New("SHOW RELAYLOG EVENTS unsupported on this server")
+
+// mysqlErrorParse is MySQL's ER_PARSE_ERROR, which is what servers not supporting SHOW RELAYLOG EVENTS respond with
+const mysqlErrorParse uint16 = 1064
+
+// mysqlErrorSpecificAccessDenied is MySQL's ER_SPECIFIC_ACCESS_DENIED_ERROR, e.g. upon SHOW BINLOG EVENTS without
+// the REPLICATION SLAVE privilege
+const mysqlErrorSpecificAccessDenied uint16 = 1227
+
+// binlogScanRequiredGrant is the grant required by the binlog scanning user
+const binlogScanRequiredGrant = "GRANT REPLICATION SLAVE, REPLICATION CLIENT ON *.*"
+
+// ErrInsufficientBinlogPrivileges is returned when the binlog scanning user is not privileged to list or read
+// binary/relay logs
+var ErrInsufficientBinlogPrivileges = errors.New(fmt.Sprintf("Insufficient privileges to read binary logs. Required: %s TO the binlog scanning user (see MySQLTopologyUser, BinlogScanCredentials)", binlogScanRequiredGrant))
+
+// checkBinlogPrivilegesError translates an access denied error, received while listing or reading binary/relay logs
+// of given instance, into ErrInsufficientBinlogPrivileges. Other errors are returned as they are.
+func checkBinlogPrivilegesError(instanceKey *InstanceKey, err error) error {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlErrorSpecificAccessDenied {
+		user, _ := getBinlogScanCredentials(instanceKey)
+		log.Errorf("User %s cannot read binary logs of %+v: %s. Required: %s TO '%s'", user, *instanceKey, mysqlErr.Message, binlogScanRequiredGrant, user)
+		return ErrInsufficientBinlogPrivileges
+	}
+	return err
+}
+
+// BinlogReader reads events off the binary logs or relay logs of an instance.
+type BinlogReader interface {
+	// ReadEvents reads up to limit events from the log file indicated by startingCoordinates, beginning at the
+	// event positioned at startingCoordinates.LogPos (a position of 0 stands for the beginning of the log).
+	// onEvent is called for each event, in order. The reader does not proceed to the next log file.
+	// If onEvent returns with error, reading stops and that error is returned.
+	ReadEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, onEvent func(event BinlogEvent) error) error
+}
+
+// EventTypesFilteringBinlogReader is optionally implemented by a BinlogReader able to spare the work of decoding
+// events of no interest to the caller. Events of types not in eventTypes are still delivered, in order, so that
+// pagination and position checks are unaffected; but their Info may be left empty.
+// SHOW BINLOG EVENTS supports no such filtering, hence the SQL reader does not implement this interface; callers
+// filter client side in any case.
+type EventTypesFilteringBinlogReader interface {
+	BinlogReader
+	ReadEventsOfTypes(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, eventTypes map[string]bool, onEvent func(event BinlogEvent) error) error
+}
+
+// readEventsOfTypes passes the eventTypes hint on to readers which support it. A nil hint stands for all events.
+func readEventsOfTypes(reader BinlogReader, instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, eventTypes map[string]bool, onEvent func(event BinlogEvent) error) error {
+	if filteringReader, ok := reader.(EventTypesFilteringBinlogReader); ok && eventTypes != nil {
+		return filteringReader.ReadEventsOfTypes(instanceKey, startingCoordinates, limit, eventTypes, onEvent)
+	}
+	return reader.ReadEvents(instanceKey, startingCoordinates, limit, onEvent)
+}
+
+// ValidateBinlogEventsColumns verifies that BinlogEventsColumns names each of the required SHOW BINLOG EVENTS
+// columns, with no column named twice. It is called upon startup.
+func ValidateBinlogEventsColumns() error {
+	columns := config.Config.BinlogEventsColumns
+	columnsMap := make(map[string]bool)
+	for _, column := range []string{columns.LogName, columns.Pos, columns.EndLogPos, columns.EventType, columns.Info, columns.ServerId} {
+		if column == "" {
+			continue
+		}
+		if columnsMap[column] {
+			return errors.New(fmt.Sprintf("BinlogEventsColumns names column %s more than once: %+v", column, columns))
+		}
+		columnsMap[column] = true
+	}
+	for _, column := range requiredBinlogEventsColumns() {
+		if column == "" {
+			return errors.New(fmt.Sprintf("BinlogEventsColumns must name all of LogName, Pos, EndLogPos, EventType, Info: %+v", columns))
+		}
+	}
+	return nil
+}
+
+// requiredBinlogEventsColumns returns the configured names of columns without which events cannot be read
+func requiredBinlogEventsColumns() []string {
+	columns := config.Config.BinlogEventsColumns
+	return []string{columns.LogName, columns.Pos, columns.EndLogPos, columns.EventType, columns.Info}
+}
+
+// QueryBinlogEvents executes a SHOW BINLOG EVENTS/SHOW RELAYLOG EVENTS query on given instance, calling onRow for
+// each resulting row. It is a variable so that tests may substitute canned responses, with no MySQL server involved.
+var QueryBinlogEvents = func(instanceKey *InstanceKey, query string, onRow func(m sqlutils.RowMap) error) error {
+	db, err := openTopologyForBinlogScan(instanceKey)
+	if err != nil {
+		return err
+	}
+	return sqlutils.QueryRowsMap(db, query, onRow)
+}
+
+// sqlBinlogReader is the default BinlogReader, reading events via SHOW BINLOG EVENTS/SHOW RELAYLOG EVENTS
+type sqlBinlogReader struct{}
+
+func (this *sqlBinlogReader) ReadEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, onEvent func(event BinlogEvent) error) error {
+	columns := config.Config.BinlogEventsColumns
+	commandToken := math.TernaryString(startingCoordinates.Type == BinaryLog, "binlog", "relaylog")
+	query := fmt.Sprintf("show %s events in '%s' FROM %d LIMIT %d", commandToken, startingCoordinates.LogFile, startingCoordinates.LogPos, limit)
+	truncatedEventsCount := 0
+	heldEvents := []BinlogEvent{}
+	rowsCount := 0
+	err := QueryBinlogEvents(instanceKey, query, func(m sqlutils.RowMap) error {
+		if rowsCount == 0 {
+			// Servers (forks) may name columns differently than configured; fail rather than read empty values
+			for _, column := range requiredBinlogEventsColumns() {
+				if _, found := m[column]; !found {
+					return errors.New(fmt.Sprintf("%s on %+v does not provide column %s (see BinlogEventsColumns)", query, *instanceKey, column))
+				}
+			}
+		}
+		rowsCount++
+		binlogEvent := BinlogEvent{}
+		binlogEvent.Coordinates.LogFile = m.GetString(columns.LogName)
+		binlogEvent.Coordinates.LogPos = m.GetInt64(columns.Pos)
+		binlogEvent.Coordinates.Type = startingCoordinates.Type
+		binlogEvent.NextEventPos = m.GetInt64(columns.EndLogPos)
+		binlogEvent.EventType = m.GetString(columns.EventType)
+		binlogEvent.Info = m.GetString(columns.Info)
+		if columns.ServerId != "" {
+			binlogEvent.ServerId = uint(m.GetInt64(columns.ServerId))
+		}
+		binlogEvent.normalizeNextEventPos()
+		if binlogEvent.IsInfoTruncated() {
+			truncatedEventsCount++
+		}
+		if truncatedEventsCount > 0 {
+			// Held back until the result set is closed; see below
+			heldEvents = append(heldEvents, binlogEvent)
+			return nil
+		}
+		return onEvent(binlogEvent)
+	})
+	if err == nil && truncatedEventsCount > 0 {
+		// Events whose Info seems truncated may be re-read via mysqlbinlog, which we only do once done with the
+		// above result set. Events following the first such event are held back as well, so as to keep the order.
+		warnTruncatedEventInfo(instanceKey, heldEvents[0], truncatedEventsCount)
+		for _, binlogEvent := range heldEvents {
+			if binlogEvent.IsInfoTruncated() {
+				binlogEvent.Info = readTruncatedEventInfo(instanceKey, binlogEvent)
+			}
+			if err = onEvent(binlogEvent); err != nil {
+				break
+			}
+		}
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlErrorParse && startingCoordinates.Type == RelayLog {
+		// This server does not support SHOW RELAYLOG EVENTS. Perhaps we can read the relay logs off the disk?
+		if directory, found := config.Config.RelayLogFilesDirectories[instanceKey.DisplayString()]; found {
+			return NewFileBinlogReader(directory).ReadEvents(instanceKey, startingCoordinates, limit, onEvent)
+		}
+		return ErrRelayLogEventsUnsupported
+	}
+	return checkBinlogPrivilegesError(instanceKey, err)
+}
+
+var defaultBinlogReader BinlogReader = &sqlBinlogReader{}
+
+// truncatedEventInfoWarnings marks hosts recently warned about events with truncated Info
+var truncatedEventInfoWarnings = cache.New(time.Minute, time.Minute)
+
+// warnTruncatedEventInfo warns about events whose Info seems truncated, at most once a minute per instance
+func warnTruncatedEventInfo(instanceKey *InstanceKey, firstEvent BinlogEvent, count int) {
+	if _, found := truncatedEventInfoWarnings.Get(instanceKey.DisplayString()); found {
+		return
+	}
+	truncatedEventInfoWarnings.Set(instanceKey.DisplayString(), true, 0)
+	log.Warningf("Info of %d events on %+v seems truncated, first of which a %s event at %+v; matching may be unreliable", count, *instanceKey, firstEvent.EventType, firstEvent.Coordinates)
+}
+
+// readTruncatedEventInfo returns the Info to use for an event whose Info seems truncated: the full statement,
+// re-read via mysqlbinlog, when BinlogEventsTruncatedInfoFallback is set; otherwise (or should the re-read fail) the
+// truncated Info as is. mysqlbinlog reads binary logs only.
+func readTruncatedEventInfo(instanceKey *InstanceKey, event BinlogEvent) string {
+	if !config.Config.BinlogEventsTruncatedInfoFallback || event.Coordinates.Type != BinaryLog {
+		return event.Info
+	}
+	info := event.Info
+	found := false
+	err := NewMysqlbinlogBinlogReader(config.Config.MysqlbinlogCommand).ReadEvents(instanceKey, event.Coordinates, 1, func(fullEvent BinlogEvent) error {
+		if fullEvent.Coordinates.Equals(&event.Coordinates) {
+			info = fullEvent.Info
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errore(err)
+	} else if !found {
+		log.Warningf("mysqlbinlog did not read the event at %+v on %+v; keeping its truncated Info", event.Coordinates, *instanceKey)
+	}
+	return info
+}
+
+// BinlogScanPermitted checks whether scanning the binary/relay logs of given instance is permitted, as per
+// BinlogScanDeniedHostnames and BinlogScanAllowedHostnames
+func BinlogScanPermitted(instanceKey *InstanceKey) bool {
+	for _, hostname := range config.Config.BinlogScanDeniedHostnames {
+		if hostname == instanceKey.Hostname {
+			return false
+		}
+	}
+	if len(config.Config.BinlogScanAllowedHostnames) == 0 {
+		return true
+	}
+	for _, hostname := range config.Config.BinlogScanAllowedHostnames {
+		if hostname == instanceKey.Hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// binlogScanCircuit tracks consecutive binlog scan failures of a host
+type binlogScanCircuit struct {
+	consecutiveFailures uint
+	openedAt            time.Time
+}
+
+var binlogScanCircuits = make(map[string]*binlogScanCircuit)
+var binlogScanCircuitsChan = make(chan bool, 1)
+
+// checkBinlogScanCircuit returns an error when the circuit of given host is open: the host has failed
+// BinlogScanCircuitBreakerFailures consecutive scans, the latest of which within BinlogScanCircuitBreakerCooldownSeconds.
+// Once the cooldown passes, a scan is let through; should it fail as well, the circuit opens anew.
+func checkBinlogScanCircuit(hostname string) error {
+	if config.Config.BinlogScanCircuitBreakerFailures == 0 {
+		return nil
+	}
+	binlogScanCircuitsChan <- true
+	defer func() { <-binlogScanCircuitsChan }()
+
+	circuit, found := binlogScanCircuits[hostname]
+	if !found || circuit.consecutiveFailures < config.Config.BinlogScanCircuitBreakerFailures {
+		return nil
+	}
+	cooldown := time.Duration(config.Config.BinlogScanCircuitBreakerCooldownSeconds) * time.Second
+	if time.Since(circuit.openedAt) >= cooldown {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Binlog scan circuit open for host %s: %d consecutive scan failures; will retry after %+v", hostname, circuit.consecutiveFailures, circuit.openedAt.Add(cooldown)))
+}
+
+// recordBinlogScanResult updates the circuit of given host following a scan attempt
+func recordBinlogScanResult(hostname string, err error) {
+	if config.Config.BinlogScanCircuitBreakerFailures == 0 {
+		return
+	}
+	binlogScanCircuitsChan <- true
+	defer func() { <-binlogScanCircuitsChan }()
+
+	if err == nil {
+		delete(binlogScanCircuits, hostname)
+		return
+	}
+	circuit, found := binlogScanCircuits[hostname]
+	if !found {
+		circuit = &binlogScanCircuit{}
+		binlogScanCircuits[hostname] = circuit
+	}
+	circuit.consecutiveFailures++
+	circuit.openedAt = time.Now()
+}
+
+// circuitBreakingBinlogReader wraps a BinlogReader, short-circuiting reads of hosts which keep failing.
+// Errors returned by the onEvent callback are the caller's own, and do not count as failures of the host.
+type circuitBreakingBinlogReader struct {
+	reader BinlogReader
+}
+
+func (this *circuitBreakingBinlogReader) ReadEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, onEvent func(event BinlogEvent) error) error {
+	return this.ReadEventsOfTypes(instanceKey, startingCoordinates, limit, nil, onEvent)
+}
+
+func (this *circuitBreakingBinlogReader) ReadEventsOfTypes(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, eventTypes map[string]bool, onEvent func(event BinlogEvent) error) error {
+	if err := checkBinlogScanCircuit(instanceKey.Hostname); err != nil {
+		return err
+	}
+	var onEventErr error
+	err := readEventsOfTypes(this.reader, instanceKey, startingCoordinates, limit, eventTypes, func(event BinlogEvent) error {
+		onEventErr = onEvent(event)
+		return onEventErr
+	})
+	if err != nil && err == onEventErr {
+		recordBinlogScanResult(instanceKey.Hostname, nil)
+	} else {
+		recordBinlogScanResult(instanceKey.Hostname, err)
+	}
+	return err
+}
+
+// ErrBinlogScanAborted is returned by scans aborted via AbortBinlogScans
+var ErrBinlogScanAborted = errors.New("Binlog scan aborted")
+
+// BinlogScanRegistration represents an active binary/relay log scan, which may be aborted via AbortBinlogScans,
+// or paused via PauseBinlogScans. A scan registers via RegisterScan, checks Aborted() as it goes, waits in between
+// chunks via WaitWhilePaused(), and finally calls Unregister().
+type BinlogScanRegistration struct {
+	Id          int64
+	Description string
+	abort       chan bool
+}
+
+var binlogScanRegistrations = make(map[int64]*BinlogScanRegistration)
+var binlogScanRegistrationsChan = make(chan bool, 1)
+var lastBinlogScanRegistrationId int64
+var binlogScansAborted = false
+
+// binlogScansResume is non-nil while scans are paused, and is closed upon resume
+var binlogScansResume chan bool
+
+// RegisterScan registers an active scan. Once AbortBinlogScans has been called, scans are registered as aborted.
+func RegisterScan(description string) *BinlogScanRegistration {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	lastBinlogScanRegistrationId++
+	registration := &BinlogScanRegistration{Id: lastBinlogScanRegistrationId, Description: description, abort: make(chan bool)}
+	if binlogScansAborted {
+		close(registration.abort)
+	}
+	binlogScanRegistrations[registration.Id] = registration
+	return registration
+}
+
+// Unregister removes this scan from the active scans
+func (this *BinlogScanRegistration) Unregister() {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	delete(binlogScanRegistrations, this.Id)
+}
+
+// Aborted returns true when this scan has been requested to stop
+func (this *BinlogScanRegistration) Aborted() bool {
+	select {
+	case <-this.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// AbortBinlogScans signals all active scans to stop, as well as any scans registered henceforth. This is intended
+// for shutdown. It returns the number of scans signaled.
+func AbortBinlogScans() int {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	if binlogScansAborted {
+		return 0
+	}
+	binlogScansAborted = true
+	for _, registration := range binlogScanRegistrations {
+		close(registration.abort)
+	}
+	return len(binlogScanRegistrations)
+}
+
+// PauseBinlogScans pauses all active scans, as well as any scans registered henceforth, until ResumeBinlogScans is
+// called. Scans pause in between chunks: a paused scan issues no further reads, and keeps its progress.
+// This allows for throttling long scans during peak traffic.
+func PauseBinlogScans() {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	if binlogScansResume == nil {
+		binlogScansResume = make(chan bool)
+	}
+}
+
+// ResumeBinlogScans resumes scans paused by PauseBinlogScans
+func ResumeBinlogScans() {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	if binlogScansResume != nil {
+		close(binlogScansResume)
+		binlogScansResume = nil
+	}
+}
+
+// BinlogScansPaused returns true while scans are paused
+func BinlogScansPaused() bool {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	return binlogScansResume != nil
+}
+
+// WaitWhilePaused blocks while scans are paused (see PauseBinlogScans). It returns ErrBinlogScanAborted should this
+// scan be aborted meanwhile.
+func (this *BinlogScanRegistration) WaitWhilePaused() error {
+	binlogScanRegistrationsChan <- true
+	resume := binlogScansResume
+	<-binlogScanRegistrationsChan
+
+	if resume == nil {
+		return nil
+	}
+	log.Debugf("Binlog scan paused: %s", this.Description)
+	select {
+	case <-resume:
+		log.Debugf("Binlog scan resumed: %s", this.Description)
+		return nil
+	case <-this.abort:
+		return ErrBinlogScanAborted
+	}
+}
+
+// ActiveBinlogScansCount returns the number of currently registered scans
+func ActiveBinlogScansCount() int {
+	binlogScanRegistrationsChan <- true
+	defer func() { <-binlogScanRegistrationsChan }()
+
+	return len(binlogScanRegistrations)
+}
+
+// getBinlogReader returns the BinlogReader to be used for reading logs of given instance.
+// Instances whose logs are mirrored on local disk (see BinlogFilesDirectories) are read directly off the
+// files; all others are read via SQL, or via mysqlbinlog as per BinlogReaderMethod.
+// All binary/relay log reads go through this function, which makes it the place to enforce scan permissions,
+// as well as the per-host circuit breaker (see BinlogScanCircuitBreakerFailures).
+// Instances mapped onto a binlog server (see BinlogServers) have their binary logs read off that server.
+func getBinlogReader(instanceKey *InstanceKey) (BinlogReader, error) {
+	if !BinlogScanPermitted(instanceKey) {
+		return nil, errors.New(fmt.Sprintf("Binlog scanning not permitted for host %s", instanceKey.Hostname))
+	}
+	if binlogServer, found := config.Config.BinlogServers[instanceKey.DisplayString()]; found {
+		binlogServerKey, err := ParseInstanceKey(binlogServer)
+		if err != nil {
+			return nil, err
+		}
+		reader := &binlogServerBinlogReader{
+			binlogServerKey:    *binlogServerKey,
+			binlogServerReader: getInstanceBinlogReader(binlogServerKey),
+			instanceReader:     getInstanceBinlogReader(instanceKey),
+		}
+		return reader, nil
+	}
+	return getInstanceBinlogReader(instanceKey), nil
+}
+
+// getInstanceBinlogReader returns the reader of given instance's own logs
+func getInstanceBinlogReader(instanceKey *InstanceKey) BinlogReader {
+	if directory, found := config.Config.BinlogFilesDirectories[instanceKey.DisplayString()]; found {
+		return &circuitBreakingBinlogReader{reader: NewFileBinlogReader(directory)}
+	}
+	if config.Config.BinlogReaderMethod == "mysqlbinlog" {
+		return &circuitBreakingBinlogReader{reader: NewMysqlbinlogBinlogReader(config.Config.MysqlbinlogCommand)}
+	}
+	return &circuitBreakingBinlogReader{reader: defaultBinlogReader}
+}
+
+// binlogServerBinlogReader reads an instance's binary logs off a binlog server, which holds them under the same
+// file names. Relay logs are local to the instance, and are read off the instance itself.
+type binlogServerBinlogReader struct {
+	binlogServerKey    InstanceKey
+	binlogServerReader BinlogReader
+	instanceReader     BinlogReader
+}
+
+func (this *binlogServerBinlogReader) ReadEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, onEvent func(event BinlogEvent) error) error {
+	return this.ReadEventsOfTypes(instanceKey, startingCoordinates, limit, nil, onEvent)
+}
+
+func (this *binlogServerBinlogReader) ReadEventsOfTypes(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, limit int, eventTypes map[string]bool, onEvent func(event BinlogEvent) error) error {
+	if startingCoordinates.Type == RelayLog {
+		return readEventsOfTypes(this.instanceReader, instanceKey, startingCoordinates, limit, eventTypes, onEvent)
+	}
+	return readEventsOfTypes(this.binlogServerReader, &this.binlogServerKey, startingCoordinates, limit, eventTypes, onEvent)
+}
+
+// scanBinlogEvents iterates the events of a single binary/relay log, starting at given coordinates, calling onEvent
+// for each event. The log is read in chunks of binlogEventsChunkSize events.
+// Iteration ends at end of log, or when onEvent returns errBinlogScanTerminated (in which case no error is returned),
+// or upon any other error, including ErrBinlogScanAborted. Event positions are verified to strictly advance, both
+// within and across chunks; a reader which does not advance results in an error rather than an endless scan.
+func scanBinlogEvents(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, onEvent func(event *BinlogEvent) error) (*BinlogScanRecord, error) {
+	return scanBinlogEventsOfTypes(instanceKey, startingCoordinates, nil, onEvent)
+}
+
+// scanBinlogEventsOfTypes is like scanBinlogEvents, passing on the eventTypes hint to the reader (see
+// EventTypesFilteringBinlogReader). All events are still iterated; the Info of events of other types may be empty.
+func scanBinlogEventsOfTypes(instanceKey *InstanceKey, startingCoordinates BinlogCoordinates, eventTypes map[string]bool, onEvent func(event *BinlogEvent) error) (*BinlogScanRecord, error) {
+	record := &BinlogScanRecord{InstanceKey: *instanceKey, Coordinates: startingCoordinates}
+	scanStartTime := time.Now()
+	var lastEventCoordinates *BinlogCoordinates
+	defer func() {
+		record.Duration = time.Since(scanStartTime)
+		if lastEventCoordinates != nil {
+			record.BytesScanned = lastEventCoordinates.LogPos - startingCoordinates.LogPos
+		}
+	}()
+
+	reader, err := getBinlogReader(instanceKey)
+	if err != nil {
+		return record, err
+	}
+	registration := RegisterScan(fmt.Sprintf("%+v %+v", *instanceKey, startingCoordinates))
+	defer registration.Unregister()
+
+	chunkCoordinates := startingCoordinates
+	for {
+		rowsRead := 0
+		newEventsRead := 0
+		err := readEventsOfTypes(reader, instanceKey, chunkCoordinates, binlogEventsChunkSize, eventTypes, func(event BinlogEvent) error {
+			rowsRead++
+			if registration.Aborted() {
+				return ErrBinlogScanAborted
+			}
+			if lastEventCoordinates != nil && event.Coordinates.Equals(lastEventCoordinates) && rowsRead == 1 {
+				// Each chunk begins with the last event of the previous chunk. See below.
+				return nil
+			}
+			if lastEventCoordinates != nil && !lastEventCoordinates.SmallerThan(&event.Coordinates) {
+				// Positions must strictly advance; otherwise we might be paginating over the same events forever
+				return errors.New(fmt.Sprintf("Binlog scan did not advance: read event at %+v following event at %+v", event.Coordinates, *lastEventCoordinates))
+			}
+			newEventsRead++
+			record.RowsScanned++
+			lastEventCoordinates = &event.Coordinates
+			return onEvent(&event)
+		})
+		if err == errBinlogScanTerminated {
+			return record, nil
+		}
+		if err != nil {
+			return record, err
+		}
+		if newEventsRead == 0 || rowsRead < binlogEventsChunkSize {
+			// End of log
+			return record, nil
+		}
+		// Next chunk begins at the last event we've read. We cannot rely on End_log_pos to point to the
+		// next event, since in relay logs End_log_pos refers to the master's binary log.
+		chunkCoordinates = *lastEventCoordinates
+		if err := registration.WaitWhilePaused(); err != nil {
+			return record, err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"encoding/json"
+	"github.com/outbrain/golib/log"
+	"io"
+	"time"
+)
+
+// BinlogScanRecord describes a completed scan of a single binary/relay log, for offline analysis of scan costs
+// (e.g. which instances are expensive to scan, whether changes to Pseudo-GTID injection improved matters)
+type BinlogScanRecord struct {
+	InstanceKey  InstanceKey
+	Operation    string
+	Coordinates  BinlogCoordinates // Where the scan began
+	RowsScanned  int64
+	BytesScanned int64 // Approximate: from the beginning of the scan up to the last event read
+	Duration     time.Duration
+	Found        bool // Whether the operation found what it scanned for (e.g. a Pseudo-GTID entry)
+	Error        string
+	Timestamp    time.Time
+}
+
+// BinlogScanRecordSink receives a record of each binary/relay log scan. It is called synchronously, from the
+// scanning goroutine, and must not block.
+type BinlogScanRecordSink interface {
+	RecordBinlogScan(record BinlogScanRecord)
+}
+
+// binlogScanRecordSink is nil by default: scans are not recorded
+var binlogScanRecordSink BinlogScanRecordSink
+
+// SetBinlogScanRecordSink sets the sink receiving a record of each scan; nil disables recording. It is expected
+// to be set up at startup, before any scanning takes place.
+func SetBinlogScanRecordSink(sink BinlogScanRecordSink) {
+	binlogScanRecordSink = sink
+}
+
+// emitBinlogScanRecord completes given scan record with the operation's outcome, and hands it to the sink, if any
+func emitBinlogScanRecord(record *BinlogScanRecord, operation string, found bool, err error) {
+	if binlogScanRecordSink == nil || record == nil {
+		return
+	}
+	record.Operation = operation
+	record.Found = found
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record.Timestamp = time.Now()
+	binlogScanRecordSink.RecordBinlogScan(*record)
+}
+
+// BinlogScanRecordChannelSink sends records onto a channel. Records are dropped, rather than have scans wait,
+// when the channel is full.
+type BinlogScanRecordChannelSink chan BinlogScanRecord
+
+func (this BinlogScanRecordChannelSink) RecordBinlogScan(record BinlogScanRecord) {
+	select {
+	case this <- record:
+	default:
+	}
+}
+
+// BinlogScanRecordWriterSink writes records onto a writer, one JSON document per line
+type BinlogScanRecordWriterSink struct {
+	encoder *json.Encoder
+	lock    chan bool
+}
+
+func NewBinlogScanRecordWriterSink(writer io.Writer) *BinlogScanRecordWriterSink {
+	return &BinlogScanRecordWriterSink{encoder: json.NewEncoder(writer), lock: make(chan bool, 1)}
+}
+
+func (this *BinlogScanRecordWriterSink) RecordBinlogScan(record BinlogScanRecord) {
+	this.lock <- true
+	defer func() { <-this.lock }()
+
+	if err := this.encoder.Encode(record); err != nil {
+		log.Errore(err)
+	}
+}
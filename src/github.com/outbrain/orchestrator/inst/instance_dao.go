@@ -1354,3 +1354,95 @@ func KillQuery(instanceKey *InstanceKey, process int64) (*Instance, error) {
 	AuditOperation("kill-query", instanceKey, fmt.Sprintf("Killed query %d", process))
 	return instance, err
 }
+
+// DetectErrantGTID returns the GTIDs executed on instance but not on master (Executed_Gtid_Set(instance) minus
+// Executed_Gtid_Set(master)); an empty result means there are none. Such errant transactions prevent a clean
+// GTID based repointing of instance. Callers may then choose to inject empty transactions on master, or abort.
+// This applies to Oracle GTID; the set subtraction is computed by instance itself, via GTID_SUBTRACT().
+func DetectErrantGTID(instance, master *Instance) (string, error) {
+	var instanceExecutedGtidSet string
+	if err := ScanInstanceRow(&instance.Key, "select @@global.gtid_executed", &instanceExecutedGtidSet); err != nil {
+		return "", log.Errore(err)
+	}
+	var masterExecutedGtidSet string
+	if err := ScanInstanceRow(&master.Key, "select @@global.gtid_executed", &masterExecutedGtidSet); err != nil {
+		return "", log.Errore(err)
+	}
+	db, err := db.OpenTopology(instance.Key.Hostname, instance.Key.Port)
+	if err != nil {
+		return "", log.Errore(err)
+	}
+	var errantGtidSet string
+	if err := db.QueryRow("select gtid_subtract(?, ?)", instanceExecutedGtidSet, masterExecutedGtidSet).Scan(&errantGtidSet); err != nil {
+		return "", log.Errore(err)
+	}
+	errantGtidSet = strings.Replace(errantGtidSet, "\n", "", -1)
+	if errantGtidSet != "" {
+		log.Debugf("Errant GTID on %+v relative to %+v: %s", instance.Key, master.Key, errantGtidSet)
+	}
+	return errantGtidSet, nil
+}
+
+// MostAdvancedInstance returns the most up-to-date of given instances, e.g. for failover candidate selection.
+// When all instances replicate via Oracle GTID, their executed GTID sets are compared. Otherwise coordinates
+// are compared: siblings (instances of the same master) by executed master coordinates, others by their own
+// binary log coordinates; the latter are only meaningful for instances sharing binary log lineage.
+func MostAdvancedInstance(instances [](*Instance)) (*Instance, error) {
+	if len(instances) == 0 {
+		return nil, errors.New("MostAdvancedInstance: no instances given")
+	}
+	allUsingOracleGTID := true
+	allSiblings := instances[0].MasterKey.IsValid()
+	for _, instance := range instances {
+		allUsingOracleGTID = allUsingOracleGTID && instance.UsingOracleGTID
+		allSiblings = allSiblings && instance.MasterKey.Equals(&instances[0].MasterKey)
+	}
+	if allUsingOracleGTID {
+		return mostAdvancedInstanceByGTID(instances)
+	}
+	mostAdvanced := instances[0]
+	for _, instance := range instances[1:] {
+		if allSiblings {
+			if mostAdvanced.ExecBinlogCoordinates.SmallerThan(&instance.ExecBinlogCoordinates) {
+				mostAdvanced = instance
+			}
+		} else if mostAdvanced.SelfBinlogCoordinates.SmallerThan(&instance.SelfBinlogCoordinates) {
+			mostAdvanced = instance
+		}
+	}
+	return mostAdvanced, nil
+}
+
+// mostAdvancedInstanceByGTID returns the instance whose executed GTID set contains those of all others.
+// Should GTID sets diverge (neither contains the other), an error is returned.
+func mostAdvancedInstanceByGTID(instances [](*Instance)) (*Instance, error) {
+	executedGtidSets := make(map[*Instance]string)
+	for _, instance := range instances {
+		var executedGtidSet string
+		if err := ScanInstanceRow(&instance.Key, "select @@global.gtid_executed", &executedGtidSet); err != nil {
+			return nil, log.Errore(err)
+		}
+		executedGtidSets[instance] = executedGtidSet
+	}
+	mostAdvanced := instances[0]
+	for _, instance := range instances[1:] {
+		db, err := db.OpenTopology(instance.Key.Hostname, instance.Key.Port)
+		if err != nil {
+			return nil, log.Errore(err)
+		}
+		var instanceContainsMostAdvanced, mostAdvancedContainsInstance bool
+		if err := db.QueryRow("select gtid_subset(?, ?), gtid_subset(?, ?)",
+			executedGtidSets[mostAdvanced], executedGtidSets[instance], executedGtidSets[instance], executedGtidSets[mostAdvanced],
+		).Scan(&instanceContainsMostAdvanced, &mostAdvancedContainsInstance); err != nil {
+			return nil, log.Errore(err)
+		}
+		if mostAdvancedContainsInstance {
+			continue
+		}
+		if !instanceContainsMostAdvanced {
+			return nil, log.Errorf("MostAdvancedInstance: executed GTID sets of %+v and %+v diverge", mostAdvanced.Key, instance.Key)
+		}
+		mostAdvanced = instance
+	}
+	return mostAdvanced, nil
+}
@@ -17,10 +17,24 @@
 package inst
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/outbrain/golib/sqlutils"
 	"github.com/outbrain/orchestrator/config"
 	"github.com/outbrain/orchestrator/inst"
 	. "gopkg.in/check.v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -111,6 +125,38 @@ func (s *TestSuite) TestBinlogPrevious(c *C) {
 	c.Assert(err, Not(IsNil))
 }
 
+func (s *TestSuite) TestBinlogNext(c *C) {
+	c1 := inst.BinlogCoordinates{LogFile: "mysql-bin.00017", LogPos: 104}
+	cres, err := c1.NextFileCoordinates()
+
+	c.Assert(err, IsNil)
+	c.Assert(c1.Type, Equals, cres.Type)
+	c.Assert(cres.LogFile, Equals, "mysql-bin.00018")
+
+	c2 := inst.BinlogCoordinates{LogFile: "mysql.00.prod.com.00099", LogPos: 104}
+	cres, err = c2.NextFileCoordinates()
+
+	c.Assert(err, IsNil)
+	c.Assert(cres.LogFile, Equals, "mysql.00.prod.com.00100")
+}
+
+func (s *TestSuite) TestParseBinlogCoordinates(c *C) {
+	c1 := inst.BinlogCoordinates{LogFile: "mysql-relay.000017", LogPos: 104, Type: inst.RelayLog}
+	cres, err := inst.ParseBinlogCoordinates(c1.String())
+	c.Assert(err, IsNil)
+	c.Assert(*cres, Equals, c1)
+
+	cres, err = inst.ParseBinlogCoordinates("mysql-bin.000017:104")
+	c.Assert(err, IsNil)
+	c.Assert(cres.Type, Equals, inst.BinaryLog)
+	c.Assert(cres.LogPos, Equals, int64(104))
+
+	_, err = inst.ParseBinlogCoordinates("mysql-bin.000017")
+	c.Assert(err, Not(IsNil))
+	_, err = inst.ParseBinlogCoordinates("mysql-bin.000017:104:other")
+	c.Assert(err, Not(IsNil))
+}
+
 func (s *TestSuite) TestBinlogCoordinatesAsKey(c *C) {
 	m := make(map[inst.BinlogCoordinates]bool)
 
@@ -176,3 +222,1356 @@ func (s *TestSuite) TestParseInstanceKey(c *C) {
 	c.Assert(i.Hostname, Equals, "127.0.0.1")
 	c.Assert(i.Port, Equals, 3306)
 }
+
+func (s *TestSuite) TestEventInfoEquals(c *C) {
+	i1 := "insert into test.t values (1, 'a  B')"
+	i2 := "INSERT  INTO test.t\n VALUES (1, 'a  B')"
+	i3 := "insert into test.t values (1, 'a b')"
+
+	config.Config.PseudoGTIDCompareCanonicalSQL = false
+	c.Assert(inst.EventInfoEquals(i1, i1), Equals, true)
+	c.Assert(inst.EventInfoEquals(i1, i2), Equals, false)
+
+	config.Config.PseudoGTIDCompareCanonicalSQL = true
+	defer func() { config.Config.PseudoGTIDCompareCanonicalSQL = false }()
+	c.Assert(inst.EventInfoEquals(i1, i2), Equals, true)
+	c.Assert(inst.EventInfoEquals(i1, i3), Equals, false)
+}
+
+func (s *TestSuite) TestNewBinlogScanCostEstimate(c *C) {
+	instanceKey := inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+	binaryLogFiles := []inst.BinaryLogFile{
+		{LogFile: "mysql-bin.000017", Size: 1024 * 1024},
+		{LogFile: "mysql-bin.000018", Size: 1024 * 1024},
+	}
+	estimate := inst.NewBinlogScanCostEstimate(&instanceKey, binaryLogFiles)
+	c.Assert(estimate.NumBinlogs, Equals, 2)
+	c.Assert(estimate.TotalBytes, Equals, int64(2*1024*1024))
+	c.Assert(estimate.EstimatedEvents, Equals, int64(8192))
+}
+
+func (s *TestSuite) TestBinlogEventRotateToMaster(c *C) {
+	event := inst.BinlogEvent{
+		Coordinates: inst.BinlogCoordinates{LogFile: "mysqld-relay-bin.000011", LogPos: 4567, Type: inst.RelayLog},
+		EventType:   "Rotate",
+		Info:        "mysql-bin.000019;pos=4",
+	}
+	rotateCoordinates := event.RotateCoordinates()
+	c.Assert(rotateCoordinates, Not(IsNil))
+	c.Assert(rotateCoordinates.LogFile, Equals, "mysql-bin.000019")
+	c.Assert(rotateCoordinates.LogPos, Equals, int64(4))
+	c.Assert(event.IsRotateToMaster(), Equals, true)
+
+	event.Info = "mysqld-relay-bin.000012;pos=4"
+	c.Assert(event.IsRotateToMaster(), Equals, false)
+
+	event.EventType = "Query"
+	c.Assert(event.RotateCoordinates(), IsNil)
+}
+
+func (s *TestSuite) TestBinlogScanPermitted(c *C) {
+	i1 := inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+	i2 := inst.InstanceKey{Hostname: "sql01.db", Port: 3306}
+	defer func() {
+		config.Config.BinlogScanAllowedHostnames = []string{}
+		config.Config.BinlogScanDeniedHostnames = []string{}
+	}()
+	c.Assert(inst.BinlogScanPermitted(&i1), Equals, true)
+
+	config.Config.BinlogScanDeniedHostnames = []string{"sql00.db"}
+	c.Assert(inst.BinlogScanPermitted(&i1), Equals, false)
+	c.Assert(inst.BinlogScanPermitted(&i2), Equals, true)
+
+	config.Config.BinlogScanDeniedHostnames = []string{}
+	config.Config.BinlogScanAllowedHostnames = []string{"sql01.db"}
+	c.Assert(inst.BinlogScanPermitted(&i1), Equals, false)
+	c.Assert(inst.BinlogScanPermitted(&i2), Equals, true)
+}
+
+func (s *TestSuite) TestBinlogEventCursorIgnorePatterns(c *C) {
+	config.Config.PseudoGTIDMatchIgnorePatterns = []string{"meta[.]heartbeat"}
+	defer func() { config.Config.PseudoGTIDMatchIgnorePatterns = []string{} }()
+
+	eventsData := [][]string{
+		{"Query", "BEGIN"},
+		{"Table_map", "table_id: 71 (meta.heartbeat)"},
+		{"Update_rows", "table_id: 71 flags: STMT_END_F"},
+		{"Xid", "COMMIT /* xid=10 */"},
+		{"Query", "BEGIN"},
+		{"Table_map", "table_id: 72 (test.t)"},
+		{"Write_rows", "table_id: 72 flags: STMT_END_F"},
+		{"Xid", "COMMIT /* xid=11 */"},
+		{"Query", "update meta.heartbeat set ts=now()"},
+	}
+	events := []inst.BinlogEvent{}
+	for i, eventData := range eventsData {
+		event := inst.BinlogEvent{
+			Coordinates:  inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: int64(100 * (i + 1)), Type: inst.BinaryLog},
+			NextEventPos: int64(100 * (i + 2)),
+			EventType:    eventData[0],
+			Info:         eventData[1],
+		}
+		events = append(events, event)
+	}
+	fetched := false
+	cursor := inst.NewBinlogEventCursor(events[0].Coordinates, func(inst.BinlogCoordinates) ([]inst.BinlogEvent, error) {
+		if fetched {
+			return []inst.BinlogEvent{}, nil
+		}
+		fetched = true
+		return events, nil
+	})
+	infos := []string{}
+	for {
+		event, err := cursor.NextRealEvent()
+		c.Assert(err, IsNil)
+		if event == nil {
+			break
+		}
+		infos = append(infos, event.Info)
+	}
+	c.Assert(infos, DeepEquals, []string{"BEGIN", "table_id: ### (test.t)", "table_id: ### flags: STMT_END_F", "COMMIT"})
+	nextCoordinates, err := cursor.NextCoordinates()
+	c.Assert(err, IsNil)
+	c.Assert(nextCoordinates.LogPos, Equals, int64(1000))
+}
+
+func (s *TestSuite) TestSortBinaryLogs(c *C) {
+	binlogs := []string{"mysql-bin.1000000", "mysql-bin.999998", "mysql-bin.999999", "mysql-bin.999997"}
+	sortedBinlogs := inst.SortBinaryLogs(binlogs)
+	c.Assert(sortedBinlogs, DeepEquals, []string{"mysql-bin.999997", "mysql-bin.999998", "mysql-bin.999999", "mysql-bin.1000000"})
+	c.Assert(binlogs[0], Equals, "mysql-bin.1000000")
+
+	reversed := []string{"mysql-bin.000019", "mysql-bin.000018", "mysql-bin.000017"}
+	c.Assert(inst.SortBinaryLogs(reversed), DeepEquals, []string{"mysql-bin.000017", "mysql-bin.000018", "mysql-bin.000019"})
+}
+
+func (s *TestSuite) TestBinlogEventParsedGTID(c *C) {
+	event := inst.BinlogEvent{EventType: "Gtid", Info: "SET @@SESSION.GTID_NEXT= '00020194-3333-3333-3333-333333333333:7'"}
+	c.Assert(event.ParsedGTID(), Equals, "00020194-3333-3333-3333-333333333333:7")
+	event.Info = "BEGIN GTID 0-1-23"
+	c.Assert(event.ParsedGTID(), Equals, "0-1-23")
+	event = inst.BinlogEvent{EventType: "Query", Info: "BEGIN"}
+	c.Assert(event.ParsedGTID(), Equals, "")
+}
+
+func (s *TestSuite) TestBinlogEventCursorWithPredicate(c *C) {
+	events := []inst.BinlogEvent{
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 4}, NextEventPos: 107, EventType: "Format_desc"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 107}, NextEventPos: 200, EventType: "Query", Info: "create table test.t (id int)"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 200}, NextEventPos: 300, EventType: "Query", Info: "BEGIN"},
+	}
+	fetched := false
+	fetchNextEvents := func(inst.BinlogCoordinates) ([]inst.BinlogEvent, error) {
+		if fetched {
+			return []inst.BinlogEvent{}, nil
+		}
+		fetched = true
+		return events, nil
+	}
+	isDDL := func(event inst.BinlogEvent) bool {
+		return event.EventType == "Query" && event.Info != "BEGIN"
+	}
+	cursor := inst.NewBinlogEventCursorWithPredicate(events[0].Coordinates, fetchNextEvents, isDDL)
+	event, err := cursor.NextRealEvent()
+	c.Assert(err, IsNil)
+	c.Assert(event.Coordinates.LogPos, Equals, int64(107))
+	event, err = cursor.NextRealEvent()
+	c.Assert(err, IsNil)
+	c.Assert(event, IsNil)
+}
+
+func (s *TestSuite) TestBinlogCoordinatesAtOffset(c *C) {
+	binaryLogFiles := []inst.BinaryLogFile{
+		{LogFile: "mysql-bin.000017", Size: 1000},
+		{LogFile: "mysql-bin.000018", Size: 500},
+	}
+	coordinates, err := inst.BinlogCoordinatesAtOffset(binaryLogFiles, 999)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogFile, Equals, "mysql-bin.000017")
+	c.Assert(coordinates.LogPos, Equals, int64(999))
+
+	coordinates, err = inst.BinlogCoordinatesAtOffset(binaryLogFiles, 1000)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogFile, Equals, "mysql-bin.000018")
+	c.Assert(coordinates.LogPos, Equals, int64(0))
+
+	coordinates, err = inst.BinlogCoordinatesAtOffset(binaryLogFiles, 1500)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogFile, Equals, "mysql-bin.000018")
+	c.Assert(coordinates.LogPos, Equals, int64(500))
+
+	_, err = inst.BinlogCoordinatesAtOffset(binaryLogFiles, 1501)
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *TestSuite) TestIsPseudoGTIDCandidate(c *C) {
+	queryEvent := inst.BinlogEvent{EventType: "Query", Info: "drop view if exists `meta`.`_pseudo_gtid_hint__123`"}
+	rowsEvent := inst.BinlogEvent{EventType: "Write_rows", Info: "table_id: 72 flags: STMT_END_F"}
+	c.Assert(inst.IsPseudoGTIDCandidate(&rowsEvent), Equals, true)
+
+	config.Config.PseudoGTIDMatchQueryEventsOnly = true
+	defer func() { config.Config.PseudoGTIDMatchQueryEventsOnly = false }()
+	c.Assert(inst.IsPseudoGTIDCandidate(&queryEvent), Equals, true)
+	c.Assert(inst.IsPseudoGTIDCandidate(&rowsEvent), Equals, false)
+}
+
+func (s *TestSuite) TestParsePseudoGTIDTimestamp(c *C) {
+	defer func() {
+		config.Config.PseudoGTIDTimestampPattern = ""
+		config.Config.PseudoGTIDTimestampHexadecimal = false
+	}()
+	entryText := "drop view if exists `meta`.`_pseudo_gtid_hint__asc:55B364E3:0000000000056EE2:6DD57B85`"
+	_, err := inst.ParsePseudoGTIDTimestamp(entryText)
+	c.Assert(err, Not(IsNil))
+
+	config.Config.PseudoGTIDTimestampPattern = `_pseudo_gtid_hint__asc:([0-9A-F]{8}):`
+	config.Config.PseudoGTIDTimestampHexadecimal = true
+	entryTime, err := inst.ParsePseudoGTIDTimestamp(entryText)
+	c.Assert(err, IsNil)
+	c.Assert(entryTime.Unix(), Equals, int64(0x55B364E3))
+}
+
+// stubEvent is a row of SHOW BINLOG EVENTS / SHOW RELAYLOG EVENTS, as served by stubBinlogEvents
+type stubEvent struct {
+	pos       int64
+	endLogPos int64
+	eventType string
+	info      string
+}
+
+var stubBinlogEventsFromRegexp = regexp.MustCompile(`FROM ([0-9]+) LIMIT ([0-9]+)`)
+
+// stubBinlogEvents replaces QueryBinlogEvents with a stub serving given rows of given log, honoring the query's
+// FROM and LIMIT. Callers are expected to restore QueryBinlogEvents.
+func stubBinlogEvents(logName string, rows []stubEvent) {
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		submatch := stubBinlogEventsFromRegexp.FindStringSubmatch(query)
+		from, _ := strconv.ParseInt(submatch[1], 10, 64)
+		limit, _ := strconv.Atoi(submatch[2])
+		for _, row := range rows {
+			if row.pos < from || limit == 0 {
+				continue
+			}
+			limit--
+			m := sqlutils.RowMap{
+				"Log_name":    sqlutils.CellData{String: logName, Valid: true},
+				"Pos":         sqlutils.CellData{String: strconv.FormatInt(row.pos, 10), Valid: true},
+				"End_log_pos": sqlutils.CellData{String: strconv.FormatInt(row.endLogPos, 10), Valid: true},
+				"Event_type":  sqlutils.CellData{String: row.eventType, Valid: true},
+				"Info":        sqlutils.CellData{String: row.info, Valid: true},
+			}
+			if err := onRow(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (s *TestSuite) TestQueryBinlogEventsStub(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, pattern string) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.PseudoGTIDPattern = pattern
+	}(inst.QueryBinlogEvents, config.Config.PseudoGTIDPattern)
+	config.Config.PseudoGTIDPattern = "_pseudo_gtid_"
+
+	infos := []string{"Server ver: 5.6.23-log, Binlog ver: 4", "BEGIN", "insert into t values (1)", "COMMIT",
+		"drop view if exists `meta`.`_pseudo_gtid_hint__1`", "BEGIN", "insert into t values (2)", "COMMIT",
+		"drop view if exists `meta`.`_pseudo_gtid_hint__2`"}
+	rows := []stubEvent{}
+	for i, info := range infos {
+		pos := int64(4 + i*100)
+		rows = append(rows, stubEvent{pos, pos + 100, "Query", info})
+	}
+	stubBinlogEvents("mysql-bin.000001", rows)
+	instanceKey := &inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+
+	entries, err := inst.ListPseudoGTIDEntriesInBinlog(instanceKey, "mysql-bin.000001", inst.BinaryLog)
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 2)
+	c.Assert(entries[1].Coordinates.LogPos, Equals, int64(804))
+
+	coordinates, err := inst.SearchPseudoGTIDEntryInBinlog(instanceKey, "mysql-bin.000001", infos[4])
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogPos, Equals, int64(404))
+
+	occurrences, err := inst.SearchAllPseudoGTIDEntryOccurrencesInBinlog(instanceKey, "mysql-bin.000001", "COMMIT")
+	c.Assert(err, IsNil)
+	c.Assert(len(occurrences), Equals, 2)
+	c.Assert(occurrences[0].LogPos, Equals, int64(304))
+	c.Assert(occurrences[1].LogPos, Equals, int64(704))
+}
+
+func (s *TestSuite) TestValidateBinlogEventsColumns(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, columns config.BinlogEventsColumns) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.BinlogEventsColumns = columns
+	}(inst.QueryBinlogEvents, config.Config.BinlogEventsColumns)
+	defaultColumns := config.Config.BinlogEventsColumns
+
+	c.Assert(inst.ValidateBinlogEventsColumns(), IsNil)
+	config.Config.BinlogEventsColumns.ServerId = ""
+	c.Assert(inst.ValidateBinlogEventsColumns(), IsNil)
+	config.Config.BinlogEventsColumns.Info = ""
+	c.Assert(inst.ValidateBinlogEventsColumns(), NotNil)
+	config.Config.BinlogEventsColumns = defaultColumns
+	config.Config.BinlogEventsColumns.Info = "Event_type"
+	c.Assert(inst.ValidateBinlogEventsColumns(), NotNil)
+
+	// A server not providing a configured column fails the read, rather than yielding empty values
+	stubBinlogEvents("mysql-bin.000001", []stubEvent{{4, 120, "Format_desc", "Server ver: 5.6.23-log, Binlog ver: 4"}})
+	instanceKey := inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+	config.Config.BinlogEventsColumns = defaultColumns
+	_, err := inst.PeekBinlogEvents(&instanceKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 4, Type: inst.BinaryLog}, 1)
+	c.Assert(err, IsNil)
+	config.Config.BinlogEventsColumns.Info = "Information"
+	_, err = inst.PeekBinlogEvents(&instanceKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 4, Type: inst.BinaryLog}, 1)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestBinlogScanCircuitBreaker(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.BinlogScanCircuitBreakerFailures = 0
+	}(inst.QueryBinlogEvents)
+	config.Config.BinlogScanCircuitBreakerFailures = 2
+
+	queryCount := 0
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		queryCount++
+		return errors.New("Connection refused")
+	}
+	instanceKey := &inst.InstanceKey{Hostname: "circuit.db", Port: 3306}
+	for i := 0; i < 2; i++ {
+		_, err := inst.ListPseudoGTIDEntriesInBinlog(instanceKey, "mysql-bin.000001", inst.BinaryLog)
+		c.Assert(err, ErrorMatches, "Connection refused")
+	}
+	_, err := inst.ListPseudoGTIDEntriesInBinlog(instanceKey, "mysql-bin.000001", inst.BinaryLog)
+	c.Assert(err, ErrorMatches, "Binlog scan circuit open for host circuit.db.*")
+	c.Assert(queryCount, Equals, 2)
+}
+
+func (s *TestSuite) TestNewPseudoGTIDInterval(c *C) {
+	defer func() {
+		config.Config.PseudoGTIDTimestampPattern = ""
+		config.Config.PseudoGTIDTimestampHexadecimal = false
+	}()
+	config.Config.PseudoGTIDTimestampPattern = `_asc:([0-9A-F]{8}):`
+	config.Config.PseudoGTIDTimestampHexadecimal = true
+	entries := []inst.PseudoGTIDEntry{
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 1000}, Text: "_asc:55B36400:"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 3000}, Text: "_asc:55B36405:"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000002", LogPos: 500}, Text: "_asc:55B3640A:"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000002", LogPos: 4500}, Text: "_asc:55B3640F:"},
+	}
+	interval := inst.NewPseudoGTIDInterval(entries)
+	c.Assert(interval.EntriesSampled, Equals, 4)
+	c.Assert(interval.PositionInterval, Equals, int64(3000))
+	c.Assert(interval.TimeInterval, Equals, 5*time.Second)
+}
+
+func (s *TestSuite) TestGoldenStream(c *C) {
+	events := []inst.BinlogEvent{
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 4}, NextEventPos: 107, EventType: "Format_desc"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 107}, NextEventPos: 200, EventType: "Query", Info: "insert into t values ('a\tb\n')"},
+		{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 200}, NextEventPos: 300, EventType: "Query", Info: "drop view if exists `meta`.`_pseudo_gtid_`"},
+	}
+	newCursor := func(events []inst.BinlogEvent) *inst.BinlogEventCursor {
+		fetched := false
+		cursor := inst.NewBinlogEventCursor(events[0].Coordinates, func(inst.BinlogCoordinates) ([]inst.BinlogEvent, error) {
+			if fetched {
+				return []inst.BinlogEvent{}, nil
+			}
+			fetched = true
+			return append([]inst.BinlogEvent{}, events...), nil
+		})
+		return &cursor
+	}
+	var golden bytes.Buffer
+	count, err := inst.WriteGoldenStream(newCursor(events), &golden, 0)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 2)
+
+	divergence, err := inst.CompareToGoldenStreamReader(newCursor(events), bytes.NewReader(golden.Bytes()))
+	c.Assert(err, IsNil)
+	c.Assert(divergence, IsNil)
+
+	events[2].Info = "drop view if exists `meta`.`_pseudo_gtid_other_`"
+	divergence, err = inst.CompareToGoldenStreamReader(newCursor(events), bytes.NewReader(golden.Bytes()))
+	c.Assert(err, IsNil)
+	c.Assert(divergence, Not(IsNil))
+	c.Assert(divergence.EventIndex, Equals, 1)
+	c.Assert(divergence.Coordinates.LogPos, Equals, int64(200))
+
+	divergence, err = inst.CompareToGoldenStreamReader(newCursor(events[:2]), bytes.NewReader(golden.Bytes()))
+	c.Assert(err, IsNil)
+	c.Assert(divergence.ActualEventType, Equals, "")
+}
+
+// appendTestBinlogEvent appends an event of given type and body onto a binary log buffer
+func appendTestBinlogEvent(buffer *bytes.Buffer, eventType byte, body []byte) {
+	eventSize := uint32(19 + len(body))
+	nextPosition := uint32(buffer.Len()) + eventSize
+	binary.Write(buffer, binary.LittleEndian, uint32(1420070400))
+	buffer.WriteByte(eventType)
+	binary.Write(buffer, binary.LittleEndian, uint32(1))
+	binary.Write(buffer, binary.LittleEndian, eventSize)
+	binary.Write(buffer, binary.LittleEndian, nextPosition)
+	binary.Write(buffer, binary.LittleEndian, uint16(0))
+	buffer.Write(body)
+}
+
+func (s *TestSuite) TestFileBinlogReader(c *C) {
+	buffer := new(bytes.Buffer)
+	buffer.Write([]byte{0xfe, 'b', 'i', 'n'})
+	{
+		body := new(bytes.Buffer)
+		binary.Write(body, binary.LittleEndian, uint16(4))
+		serverVersion := make([]byte, 50)
+		copy(serverVersion, "5.5.40-log")
+		body.Write(serverVersion)
+		binary.Write(body, binary.LittleEndian, uint32(0))
+		body.WriteByte(19)
+		postHeaderLengths := make([]byte, 27)
+		postHeaderLengths[1] = 13
+		postHeaderLengths[3] = 8
+		body.Write(postHeaderLengths)
+		appendTestBinlogEvent(buffer, 15, body.Bytes())
+	}
+	{
+		body := new(bytes.Buffer)
+		binary.Write(body, binary.LittleEndian, uint32(7))
+		binary.Write(body, binary.LittleEndian, uint32(0))
+		body.WriteByte(byte(len("test")))
+		binary.Write(body, binary.LittleEndian, uint16(0))
+		binary.Write(body, binary.LittleEndian, uint16(0))
+		body.WriteString("test")
+		body.WriteByte(0)
+		body.WriteString("drop view if exists `meta`.`_pseudo_gtid_hint__123`")
+		appendTestBinlogEvent(buffer, 2, body.Bytes())
+	}
+	{
+		body := new(bytes.Buffer)
+		binary.Write(body, binary.LittleEndian, uint64(42))
+		appendTestBinlogEvent(buffer, 16, body.Bytes())
+	}
+	{
+		body := new(bytes.Buffer)
+		binary.Write(body, binary.LittleEndian, uint64(4))
+		body.WriteString("mysql-bin.000018")
+		appendTestBinlogEvent(buffer, 4, body.Bytes())
+	}
+	directory, err := ioutil.TempDir("", "orchestrator-test")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(directory)
+	err = ioutil.WriteFile(filepath.Join(directory, "mysql-bin.000017"), buffer.Bytes(), 0644)
+	c.Assert(err, IsNil)
+
+	instanceKey := inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+	reader := inst.NewFileBinlogReader(directory)
+	events := []inst.BinlogEvent{}
+	err = reader.ReadEvents(&instanceKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 0, Type: inst.BinaryLog}, 100, func(event inst.BinlogEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(len(events), Equals, 4)
+	c.Assert(events[0].EventType, Equals, "Format_desc")
+	c.Assert(events[0].Info, Equals, "Server ver: 5.5.40-log, Binlog ver: 4")
+	c.Assert(events[0].Coordinates.LogPos, Equals, int64(4))
+	c.Assert(events[1].EventType, Equals, "Query")
+	c.Assert(events[1].Info, Equals, "use `test`; drop view if exists `meta`.`_pseudo_gtid_hint__123`")
+	c.Assert(events[1].Coordinates.LogPos, Equals, events[0].NextEventPos)
+	c.Assert(events[2].Info, Equals, "COMMIT /* xid=42 */")
+	c.Assert(events[3].EventType, Equals, "Rotate")
+	c.Assert(events[3].Info, Equals, "mysql-bin.000018;pos=4")
+	c.Assert(events[3].NextEventPos, Equals, int64(buffer.Len()))
+
+	// Start reading mid-file
+	var xidEvent *inst.BinlogEvent
+	err = reader.ReadEvents(&instanceKey, events[2].Coordinates, 1, func(event inst.BinlogEvent) error {
+		xidEvent = &event
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(xidEvent, Not(IsNil))
+	c.Assert(xidEvent.Coordinates.Equals(&events[2].Coordinates), Equals, true)
+	c.Assert(xidEvent.Info, Equals, events[2].Info)
+}
+
+func (s *TestSuite) TestParseMysqlbinlogOutput(c *C) {
+	output := `/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;
+/*!40019 SET @@session.max_insert_delayed_threads=0*/;
+DELIMITER /*!*/;
+# at 4
+#150725 12:34:56 server id 1  end_log_pos 120 CRC32 0x1a2b3c4d 	Start: binlog v 4, server v 5.6.25-log created 150725 12:34:56
+# at 120
+#150725 12:35:01 server id 1  end_log_pos 199 CRC32 0x0badf00d 	Query	thread_id=7	exec_time=0	error_code=0
+SET TIMESTAMP=1437816901/*!*/;
+SET @@session.pseudo_thread_id=7/*!*/;
+BEGIN
+/*!*/;
+# at 199
+#150725 12:35:01 server id 1  end_log_pos 311 CRC32 0x0badf00d 	Query	thread_id=7	exec_time=0	error_code=0
+use ` + "`test`" + `/*!*/;
+SET TIMESTAMP=1437816901/*!*/;
+drop view if exists ` + "`meta`.`_pseudo_gtid_hint__123`" + `
+/*!*/;
+# at 311
+#150725 12:35:01 server id 1  end_log_pos 342 CRC32 0x0badf00d 	Xid = 42
+COMMIT/*!*/;
+# at 342
+#150725 12:35:02 server id 1  end_log_pos 389 CRC32 0x0badf00d 	Rotate to mysql-bin.000018  pos: 4
+# End of log file
+ROLLBACK /* added by mysqlbinlog */ /*!*/;
+DELIMITER ;
+`
+	events := []inst.BinlogEvent{}
+	err := inst.ParseMysqlbinlogOutput(bytes.NewBufferString(output), "mysql-bin.000017", func(event inst.BinlogEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(len(events), Equals, 5)
+	c.Assert(events[0].EventType, Equals, "Format_desc")
+	c.Assert(events[0].Info, Equals, "Server ver: 5.6.25-log, Binlog ver: 4")
+	c.Assert(events[0].Coordinates.LogPos, Equals, int64(4))
+	c.Assert(events[0].NextEventPos, Equals, int64(120))
+	c.Assert(events[0].Timestamp.Format("2006-01-02 15:04:05"), Equals, "2015-07-25 12:34:56")
+	c.Assert(events[0].ServerId, Equals, uint(1))
+	c.Assert(events[1].Info, Equals, "BEGIN")
+	c.Assert(events[2].EventType, Equals, "Query")
+	c.Assert(events[2].Info, Equals, "use `test`; drop view if exists `meta`.`_pseudo_gtid_hint__123`")
+	c.Assert(events[2].Coordinates.LogFile, Equals, "mysql-bin.000017")
+	c.Assert(events[3].Info, Equals, "COMMIT /* xid=42 */")
+	c.Assert(events[4].EventType, Equals, "Rotate")
+	c.Assert(events[4].Info, Equals, "mysql-bin.000018;pos=4")
+	c.Assert(events[4].NextEventPos, Equals, int64(389))
+
+	// With an event types hint, other events are delivered without their info
+	events = []inst.BinlogEvent{}
+	err = inst.ParseMysqlbinlogOutputOfTypes(bytes.NewBufferString(output), "mysql-bin.000017", map[string]bool{"Query": true}, func(event inst.BinlogEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(len(events), Equals, 5)
+	c.Assert(events[2].Info, Equals, "use `test`; drop view if exists `meta`.`_pseudo_gtid_hint__123`")
+	c.Assert(events[3].EventType, Equals, "Xid")
+	c.Assert(events[3].Info, Equals, "")
+	c.Assert(events[3].Coordinates.LogPos, Equals, int64(311))
+}
+
+// fakeMysqlbinlogCommand creates an executable which, regardless of its arguments, prints given output as
+// mysqlbinlog would. It returns the executable's path, and a cleanup function.
+func fakeMysqlbinlogCommand(c *C, output string) (string, func()) {
+	directory, err := ioutil.TempDir("", "orchestrator-test")
+	c.Assert(err, IsNil)
+	outputFile := filepath.Join(directory, "mysqlbinlog.out")
+	c.Assert(ioutil.WriteFile(outputFile, []byte(output), 0644), IsNil)
+	command := filepath.Join(directory, "mysqlbinlog")
+	c.Assert(ioutil.WriteFile(command, []byte(fmt.Sprintf("#!/bin/sh\ncat %s\n", outputFile)), 0755), IsNil)
+	return command, func() { os.RemoveAll(directory) }
+}
+
+// mysqlbinlogStartPositionOutput is mysqlbinlog output as of --start-position=199: the Format_desc event is
+// printed regardless, ahead of the requested events
+const mysqlbinlogStartPositionOutput = `/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;
+/*!40019 SET @@session.max_insert_delayed_threads=0*/;
+/*!50003 SET @OLD_COMPLETION_TYPE=@@COMPLETION_TYPE,COMPLETION_TYPE=0*/;
+DELIMITER /*!*/;
+# at 4
+#150725 12:34:56 server id 1  end_log_pos 120 CRC32 0x1a2b3c4d 	Start: binlog v 4, server v 5.6.25-log created 150725 12:34:56
+BINLOG '
+QG2zVQ8BAAAAdAAAAHgAAAAAAAQANS42LjI1LWxvZwAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+'/*!*/;
+# at 199
+#150725 12:35:01 server id 1  end_log_pos 311 CRC32 0x0badf00d 	Query	thread_id=7	exec_time=0	error_code=0
+use ` + "`test`" + `/*!*/;
+SET TIMESTAMP=1437816901/*!*/;
+drop view if exists ` + "`meta`.`_pseudo_gtid_hint__123`" + `
+/*!*/;
+# at 311
+#150725 12:35:01 server id 1  end_log_pos 342 CRC32 0x0badf00d 	Xid = 42
+COMMIT/*!*/;
+# at 342
+#150725 12:35:02 server id 1  end_log_pos 389 CRC32 0x0badf00d 	Rotate to mysql-bin.000018  pos: 4
+# End of log file
+ROLLBACK /* added by mysqlbinlog */ /*!*/;
+DELIMITER ;
+`
+
+func (s *TestSuite) TestMysqlbinlogReaderStartPosition(c *C) {
+	command, cleanup := fakeMysqlbinlogCommand(c, mysqlbinlogStartPositionOutput)
+	defer cleanup()
+
+	instanceKey := &inst.InstanceKey{Hostname: "mysqlbinlog.db", Port: 3306}
+	events := []inst.BinlogEvent{}
+	err := inst.NewMysqlbinlogBinlogReader(command).ReadEvents(instanceKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 199, Type: inst.BinaryLog}, 2, func(event inst.BinlogEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(len(events), Equals, 2)
+	c.Assert(events[0].Coordinates.LogPos, Equals, int64(199))
+	c.Assert(events[0].Info, Equals, "use `test`; drop view if exists `meta`.`_pseudo_gtid_hint__123`")
+	c.Assert(events[1].Coordinates.LogPos, Equals, int64(311))
+}
+
+func (s *TestSuite) TestBinlogEventCursorReleasesInfo(c *C) {
+	chunks := [][]inst.BinlogEvent{
+		{
+			{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 100}, NextEventPos: 200, EventType: "Query", Info: "insert into t values (1)"},
+			{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 200}, NextEventPos: 300, EventType: "Query", Info: "insert into t values (2)"},
+		},
+		{
+			{Coordinates: inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 300}, NextEventPos: 400, EventType: "Query", Info: "insert into t values (3)"},
+		},
+		{},
+	}
+	fetches := 0
+	cursor := inst.NewBinlogEventCursor(inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 100}, func(coordinates inst.BinlogCoordinates) ([]inst.BinlogEvent, error) {
+		fetches++
+		return chunks[fetches-1], nil
+	})
+
+	first, err := cursor.NextEvent()
+	c.Assert(err, IsNil)
+	second, err := cursor.NextEvent()
+	c.Assert(err, IsNil)
+	// The consumed event's Info is released from the cursor's cache...
+	c.Assert(chunks[0][0].Info, Equals, "")
+	// ...while events handed out remain intact
+	c.Assert(first.Info, Equals, "insert into t values (1)")
+	c.Assert(second.Info, Equals, "insert into t values (2)")
+	c.Assert(cursor.LastEvent().Info, Equals, "insert into t values (2)")
+
+	third, err := cursor.NextEvent()
+	c.Assert(err, IsNil)
+	c.Assert(third.Info, Equals, "insert into t values (3)")
+	c.Assert(second.Info, Equals, "insert into t values (2)")
+	c.Assert(cursor.LastEvent().Info, Equals, "insert into t values (3)")
+
+	end, err := cursor.NextEvent()
+	c.Assert(err, IsNil)
+	c.Assert(end, IsNil)
+	c.Assert(cursor.LastEvent().Info, Equals, "insert into t values (3)")
+}
+
+func (s *TestSuite) TestBinlogPositionsBeyond4GB(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+	}(inst.QueryBinlogEvents)
+
+	// Events straddling the 4GB mark; End_log_pos is a 32 bit header field and wraps around
+	basePos := int64(1<<32) - 150
+	rows := []stubEvent{}
+	for i := int64(0); i < 5; i++ {
+		pos := basePos + i*100
+		rows = append(rows, stubEvent{pos, (pos + 100) & 0xFFFFFFFF, "Query", "insert into t values (1)"})
+	}
+	stubBinlogEvents("mysql-bin.000001", rows)
+	stubbedQueryBinlogEvents := inst.QueryBinlogEvents
+	queries := []string{}
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		queries = append(queries, query)
+		return stubbedQueryBinlogEvents(instanceKey, query, onRow)
+	}
+	instanceKey := &inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+
+	cursor := inst.NewBinlogEventCursor(inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: basePos, Type: inst.BinaryLog}, func(coordinates inst.BinlogCoordinates) ([]inst.BinlogEvent, error) {
+		return inst.PeekBinlogEvents(instanceKey, coordinates, 2)
+	})
+	for i := int64(0); i < 5; i++ {
+		event, err := cursor.NextEvent()
+		c.Assert(err, IsNil)
+		c.Assert(event, Not(IsNil))
+		c.Assert(event.Coordinates.LogPos, Equals, basePos+i*100)
+		c.Assert(event.NextEventPos, Equals, basePos+(i+1)*100)
+	}
+	c.Assert(queries[len(queries)-1], Matches, fmt.Sprintf(".*FROM %d LIMIT 2", basePos+400))
+}
+
+func (s *TestSuite) TestInsufficientBinlogPrivileges(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+	}(inst.QueryBinlogEvents)
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		return &mysql.MySQLError{Number: 1227, Message: "Access denied; you need (at least one of) the REPLICATION SLAVE privilege(s) for this operation"}
+	}
+	instanceKey := &inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+	_, err := inst.PeekBinlogEvents(instanceKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 0, Type: inst.BinaryLog}, 1)
+	c.Assert(err, Equals, inst.ErrInsufficientBinlogPrivileges)
+}
+
+func (s *TestSuite) TestNewBinlogScanProgress(c *C) {
+	progress := inst.NewBinlogScanProgress(1000, 10*time.Second, 4000)
+	c.Assert(progress.EstimatedRemaining, Equals, 30*time.Second)
+
+	progress = inst.NewBinlogScanProgress(0, 0, 4000)
+	c.Assert(progress.EstimatedRemaining, Equals, time.Duration(0))
+
+	// Scan went beyond the estimate
+	progress = inst.NewBinlogScanProgress(5000, 10*time.Second, 4000)
+	c.Assert(progress.EstimatedRemaining, Equals, time.Duration(0))
+}
+
+func (s *TestSuite) TestPauseBinlogScans(c *C) {
+	defer inst.ResumeBinlogScans()
+
+	registration := inst.RegisterScan("test scan")
+	defer registration.Unregister()
+	c.Assert(registration.WaitWhilePaused(), IsNil)
+
+	inst.PauseBinlogScans()
+	c.Assert(inst.BinlogScansPaused(), Equals, true)
+	resumed := make(chan error)
+	go func() { resumed <- registration.WaitWhilePaused() }()
+	stillPaused := true
+	select {
+	case <-resumed:
+		stillPaused = false
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Assert(stillPaused, Equals, true)
+	inst.ResumeBinlogScans()
+	c.Assert(<-resumed, IsNil)
+	c.Assert(inst.BinlogScansPaused(), Equals, false)
+}
+
+func (s *TestSuite) TestPauseBinlogScansChunkReads(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		inst.ResumeBinlogScans()
+	}(inst.QueryBinlogEvents)
+	stubBinlogEvents("mysql-bin.000001", []stubEvent{{4, 120, "Format_desc", "Server ver: 5.6.16-log, Binlog ver: 4"}})
+	stub := inst.QueryBinlogEvents
+	queried := make(chan bool, 1)
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		queried <- true
+		return stub(instanceKey, query, onRow)
+	}
+
+	inst.PauseBinlogScans()
+	instanceKey := &inst.InstanceKey{Hostname: "sql00.db", Port: 3306}
+	result := make(chan error)
+	go func() {
+		_, err := inst.PeekBinlogEvents(instanceKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 4, Type: inst.BinaryLog}, 1)
+		result <- err
+	}()
+	queriedWhilePaused := false
+	select {
+	case <-queried:
+		queriedWhilePaused = true
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Assert(queriedWhilePaused, Equals, false)
+	c.Assert(inst.ActiveBinlogScansCount(), Equals, 1)
+
+	inst.ResumeBinlogScans()
+	c.Assert(<-result, IsNil)
+	c.Assert(<-queried, Equals, true)
+}
+
+func (s *TestSuite) TestProbeLastPseudoGTIDEntryInTail(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, pattern string) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.PseudoGTIDPattern = pattern
+	}(inst.QueryBinlogEvents, config.Config.PseudoGTIDPattern)
+	config.Config.PseudoGTIDPattern = "_pseudo_gtid_"
+
+	// A binary log longer than a chunk of events (1000000), with entries at its head and near its end
+	const eventsCount = 1000100
+	entryText := func(pos int64) string {
+		switch pos {
+		case 4 + 10*100, 4 + (eventsCount-50)*100:
+			return fmt.Sprintf("drop view if exists `_pseudo_gtid_`.`_asc:%d`", pos)
+		}
+		return "BEGIN"
+	}
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		submatch := stubBinlogEventsFromRegexp.FindStringSubmatch(query)
+		from, _ := strconv.ParseInt(submatch[1], 10, 64)
+		limit, _ := strconv.ParseInt(submatch[2], 10, 64)
+		if from < 4 {
+			from = 4
+		}
+		for pos := from; pos < 4+eventsCount*100 && pos < from+limit*100; pos += 100 {
+			m := sqlutils.RowMap{
+				"Log_name":    sqlutils.CellData{String: "mysql-bin.000007", Valid: true},
+				"Pos":         sqlutils.CellData{String: strconv.FormatInt(pos, 10), Valid: true},
+				"End_log_pos": sqlutils.CellData{String: strconv.FormatInt(pos+100, 10), Valid: true},
+				"Event_type":  sqlutils.CellData{String: "Query", Valid: true},
+				"Info":        sqlutils.CellData{String: entryText(pos), Valid: true},
+			}
+			if err := onRow(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "tailprobe.db", Port: 3306}
+	instance.SetBinaryLogs([]string{"mysql-bin.000006", "mysql-bin.000007"})
+	instance.SelfBinlogCoordinates = inst.BinlogCoordinates{LogFile: "mysql-bin.000007", LogPos: 4 + eventsCount*100}
+
+	// The first chunk does not reach the tail: the entry at the head is not reported as the latest
+	coordinates, _, err := inst.ProbeLastPseudoGTIDEntryInTail(instance)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates, IsNil)
+
+	coordinates, text, err := inst.ProbeLastPseudoGTIDEntryInTail(instance)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates, Not(IsNil))
+	c.Assert(coordinates.LogPos, Equals, int64(4+(eventsCount-50)*100))
+	c.Assert(text, Equals, entryText(coordinates.LogPos))
+}
+
+func (s *TestSuite) TestMostAdvancedInstance(c *C) {
+	masterKey := inst.InstanceKey{Hostname: "master.db", Port: 3306}
+	slaves := [](*inst.Instance){}
+	for i, logPos := range []int64{400, 700, 500} {
+		slave := inst.NewInstance()
+		slave.Key = inst.InstanceKey{Hostname: fmt.Sprintf("slave%d.db", i), Port: 3306}
+		slave.MasterKey = masterKey
+		slave.ExecBinlogCoordinates = inst.BinlogCoordinates{LogFile: "mysql-bin.000010", LogPos: logPos}
+		slave.SelfBinlogCoordinates = inst.BinlogCoordinates{LogFile: "mysql-bin.000020", LogPos: 1000 - logPos}
+		slaves = append(slaves, slave)
+	}
+	mostAdvanced, err := inst.MostAdvancedInstance(slaves)
+	c.Assert(err, IsNil)
+	c.Assert(mostAdvanced.Key.Hostname, Equals, "slave1.db")
+
+	_, err = inst.MostAdvancedInstance([](*inst.Instance){})
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *TestSuite) TestBinlogServers(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.BinlogServers = make(map[string]string)
+	}(inst.QueryBinlogEvents)
+	config.Config.BinlogServers = map[string]string{"master.db:3306": "binlogserver.db:3307"}
+
+	queriedKeys := []string{}
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		queriedKeys = append(queriedKeys, instanceKey.DisplayString())
+		return nil
+	}
+	masterKey := &inst.InstanceKey{Hostname: "master.db", Port: 3306}
+	_, err := inst.PeekBinlogEvents(masterKey, inst.BinlogCoordinates{LogFile: "mysql-bin.000001", LogPos: 0, Type: inst.BinaryLog}, 1)
+	c.Assert(err, IsNil)
+	_, err = inst.PeekBinlogEvents(masterKey, inst.BinlogCoordinates{LogFile: "mysql-relay.000001", LogPos: 0, Type: inst.RelayLog}, 1)
+	c.Assert(err, IsNil)
+	c.Assert(queriedKeys, DeepEquals, []string{"binlogserver.db:3307", "master.db:3306"})
+}
+
+func (s *TestSuite) TestPseudoGTIDPatternBenignMatches(c *C) {
+	defer func(pattern string) {
+		config.Config.PseudoGTIDPattern = pattern
+	}(config.Config.PseudoGTIDPattern)
+
+	config.Config.PseudoGTIDPattern = "drop view if exists `meta`.`_pseudo_gtid_hint__"
+	matches, err := inst.PseudoGTIDPatternBenignMatches()
+	c.Assert(err, IsNil)
+	c.Assert(len(matches), Equals, 0)
+
+	config.Config.PseudoGTIDPattern = "COMMIT"
+	matches, err = inst.PseudoGTIDPatternBenignMatches()
+	c.Assert(err, IsNil)
+	c.Assert(len(matches), Equals, 2)
+	c.Assert(inst.ValidatePseudoGTIDPattern(), NotNil)
+}
+
+func (s *TestSuite) TestReplayBinlogCoordinatesMatch(c *C) {
+	recording := `{"InstanceKey":{"Hostname":"i0","Port":3306},"InstanceSelfBinlogCoordinates":{"LogFile":"mysql-bin.000001","LogPos":300},"InstanceCoordinates":{"LogFile":"mysql-bin.000001","LogPos":100},"OtherKey":{"Hostname":"i1","Port":3306},"OtherCoordinates":{"LogFile":"mysql-bin.000007","LogPos":500}}
+{"Side":"instance","Coordinates":{"LogFile":"mysql-bin.000001","LogPos":100},"Events":[{"Coordinates":{"LogFile":"mysql-bin.000001","LogPos":100},"NextEventPos":200,"EventType":"Query","Info":"insert into t values (1)"},{"Coordinates":{"LogFile":"mysql-bin.000001","LogPos":200},"NextEventPos":300,"EventType":"Query","Info":"insert into t values (2)"}]}
+{"Side":"instance","Coordinates":{"LogFile":"mysql-bin.000001","LogPos":300},"Events":[]}
+{"Side":"other","Coordinates":{"LogFile":"mysql-bin.000007","LogPos":500},"Events":[{"Coordinates":{"LogFile":"mysql-bin.000007","LogPos":500},"NextEventPos":600,"EventType":"Query","Info":"insert into t values (1)"},{"Coordinates":{"LogFile":"mysql-bin.000007","LogPos":600},"NextEventPos":700,"EventType":"Query","Info":"insert into t values (2)"},{"Coordinates":{"LogFile":"mysql-bin.000007","LogPos":700},"NextEventPos":800,"EventType":"Query","Info":"insert into t values (3)"}]}
+`
+	result, err := inst.ReplayBinlogCoordinatesMatchReader(bytes.NewBufferString(recording), nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.TargetCoordinates.LogFile, Equals, "mysql-bin.000007")
+	c.Assert(result.TargetCoordinates.LogPos, Equals, int64(700))
+	c.Assert(result.InstanceEndedAtSelfCoordinates, Equals, true)
+
+	// A diverging stream is reproduced as well
+	recording = strings.Replace(recording, `"insert into t values (2)"}]}`, `"insert into t values (4)"}]}`, 1)
+	_, err = inst.ReplayBinlogCoordinatesMatchReader(bytes.NewBufferString(recording), nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestGetBinlogPreviousGTIDs(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+	}(inst.QueryBinlogEvents)
+
+	stubBinlogEvents("mysql-bin.000002", []stubEvent{
+		{4, 120, "Format_desc", "Server ver: 5.6.25-log, Binlog ver: 4"},
+		{120, 191, "Previous_gtids", "00020194-3333-3333-3333-333333333333:1-7,\n00020194-4444-4444-4444-444444444444:1-3"},
+	})
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "gtid00.db", Port: 3306}
+	previousGTIDs, err := inst.GetBinlogPreviousGTIDs(instance, "mysql-bin.000002")
+	c.Assert(err, IsNil)
+	c.Assert(previousGTIDs, Equals, "00020194-3333-3333-3333-333333333333:1-7,00020194-4444-4444-4444-444444444444:1-3")
+}
+
+func (s *TestSuite) TestCommitMarkerTolerantEventComparator(c *C) {
+	comparator := inst.CommitMarkerTolerantEventComparator{Comparator: inst.ExactEventComparator{}}
+	xidEvent := inst.BinlogEvent{EventType: "Xid", Info: "COMMIT /* xid=42 */"}
+	queryCommitEvent := inst.BinlogEvent{EventType: "Query", Info: "use `test`; COMMIT"}
+	xaCommitEvent := inst.BinlogEvent{EventType: "Query", Info: "XA COMMIT 'xid'"}
+	c.Assert(inst.ExactEventComparator{}.Equal(xidEvent, queryCommitEvent), Equals, false)
+	c.Assert(comparator.Equal(xidEvent, queryCommitEvent), Equals, true)
+	c.Assert(comparator.Equal(xidEvent, xaCommitEvent), Equals, false)
+	c.Assert(comparator.Equal(queryCommitEvent, inst.BinlogEvent{EventType: "Query", Info: "BEGIN"}), Equals, false)
+}
+
+func (s *TestSuite) TestBinlogScanRecordSink(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, pattern string) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.PseudoGTIDPattern = pattern
+		inst.SetBinlogScanRecordSink(nil)
+	}(inst.QueryBinlogEvents, config.Config.PseudoGTIDPattern)
+
+	config.Config.PseudoGTIDPattern = "_pseudo_gtid_"
+	stubBinlogEvents("mysql-bin.000003", []stubEvent{
+		{120, 220, "Query", "insert into t values (1)"},
+		{220, 320, "Query", "drop view if exists `_pseudo_gtid_`.`_asc:55b2f1f5:00000001`"},
+	})
+	records := make(inst.BinlogScanRecordChannelSink, 1)
+	inst.SetBinlogScanRecordSink(records)
+
+	instanceKey := &inst.InstanceKey{Hostname: "scan00.db", Port: 3306}
+	entries, err := inst.ListPseudoGTIDEntriesInBinlog(instanceKey, "mysql-bin.000003", inst.BinaryLog)
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 1)
+	record := <-records
+	c.Assert(record.Operation, Equals, "ListPseudoGTIDEntries")
+	c.Assert(record.InstanceKey.Equals(instanceKey), Equals, true)
+	c.Assert(record.RowsScanned, Equals, int64(2))
+	c.Assert(record.BytesScanned, Equals, int64(220))
+	c.Assert(record.Found, Equals, true)
+}
+
+func (s *TestSuite) TestBinlogEventsStrictPositions(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, strict bool) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.BinlogEventsStrictPositions = strict
+	}(inst.QueryBinlogEvents, config.Config.BinlogEventsStrictPositions)
+
+	stubBinlogEvents("mysql-bin.000004", []stubEvent{
+		{120, 220, "Query", "insert into t values (1)"},
+		{220, 0, "Query", "insert into t values (1)"},
+	})
+	instanceKey := &inst.InstanceKey{Hostname: "strict00.db", Port: 3306}
+	coordinates := inst.BinlogCoordinates{LogFile: "mysql-bin.000004", LogPos: 120, Type: inst.BinaryLog}
+
+	config.Config.BinlogEventsStrictPositions = false
+	events, err := inst.PeekBinlogEvents(instanceKey, coordinates, 2)
+	c.Assert(err, IsNil)
+	c.Assert(len(events), Equals, 2)
+
+	config.Config.BinlogEventsStrictPositions = true
+	_, err = inst.PeekBinlogEvents(instanceKey, coordinates, 2)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestBinlogMatchFetchRetries(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, retries uint) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.BinlogMatchFetchRetries = retries
+	}(inst.QueryBinlogEvents, config.Config.BinlogMatchFetchRetries)
+	config.Config.BinlogMatchFetchRetries = 1
+
+	stubBinlogEvents("mysql-relay.000001", []stubEvent{
+		{100, 1100, "Query", "insert into t values (1)"},
+		{200, 1200, "Query", "insert into t values (2)"},
+		{300, 1300, "Query", "insert into t values (3)"},
+	})
+	instanceQueryBinlogEvents := inst.QueryBinlogEvents
+	stubBinlogEvents("mysql-bin.000007", []stubEvent{
+		{500, 600, "Query", "insert into t values (1)"},
+		{600, 700, "Query", "insert into t values (2)"},
+		{700, 800, "Query", "insert into t values (3)"},
+	})
+	otherQueryBinlogEvents := inst.QueryBinlogEvents
+
+	// other's first read fails with given error
+	var otherFailure error
+	otherQueries := 0
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		if instanceKey.Hostname == "retry0.db" {
+			return instanceQueryBinlogEvents(instanceKey, query, onRow)
+		}
+		otherQueries++
+		if otherQueries == 1 {
+			return otherFailure
+		}
+		return otherQueryBinlogEvents(instanceKey, query, onRow)
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "retry0.db", Port: 3306}
+	other := inst.NewInstance()
+	other.Key = inst.InstanceKey{Hostname: "retry1.db", Port: 3306}
+	match := func() (*inst.BinlogCoordinates, error) {
+		otherQueries = 0
+		return inst.GetNextBinlogCoordinatesToMatch(instance, inst.BinlogCoordinates{LogFile: "mysql-relay.000001", LogPos: 100, Type: inst.RelayLog},
+			inst.BinlogCoordinates{LogFile: "mysql-relay.000001", LogPos: 300, Type: inst.RelayLog},
+			other, inst.BinlogCoordinates{LogFile: "mysql-bin.000007", LogPos: 500, Type: inst.BinaryLog}, nil)
+	}
+
+	otherFailure = io.ErrUnexpectedEOF
+	coordinates, err := match()
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogPos, Equals, int64(700))
+	c.Assert(otherQueries > 1, Equals, true)
+
+	otherFailure = errors.New("Binlog scan circuit open for host retry1.db")
+	_, err = match()
+	c.Assert(err, NotNil)
+	c.Assert(otherQueries, Equals, 1)
+}
+
+func (s *TestSuite) TestFindCommonPseudoGTIDEntryFallbackAnchors(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, fallbackAnchors uint) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.PseudoGTIDMatchMaxFallbackAnchors = fallbackAnchors
+	}(inst.QueryBinlogEvents, config.Config.PseudoGTIDMatchMaxFallbackAnchors)
+	config.Config.PseudoGTIDMatchMaxFallbackAnchors = 3
+
+	instanceQueries := 0
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		if instanceKey.Hostname == "anchor0.db" {
+			instanceQueries++
+			return nil
+		}
+		return errors.New("Connection refused")
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "anchor0.db", Port: 3306}
+	other := inst.NewInstance()
+	other.Key = inst.InstanceKey{Hostname: "anchor1.db", Port: 3306}
+	other.SetBinaryLogs([]string{"mysql-bin.000001"})
+	anchorCoordinates := inst.BinlogCoordinates{LogFile: "mysql-bin.000005", LogPos: 400, Type: inst.BinaryLog}
+
+	// A failed search on other is final: no fallback to older anchors of instance
+	_, _, _, err := inst.FindCommonPseudoGTIDEntry(instance, &anchorCoordinates, "drop view if exists `meta`.`_pseudo_gtid_hint__anchor`", other)
+	c.Assert(err, ErrorMatches, "Connection refused")
+	c.Assert(instanceQueries, Equals, 0)
+}
+
+func (s *TestSuite) TestTranslateBinlogCoordinates(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, pattern string, lookahead uint) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.PseudoGTIDPattern = pattern
+		config.Config.PseudoGTIDMatchMismatchLookahead = lookahead
+	}(inst.QueryBinlogEvents, config.Config.PseudoGTIDPattern, config.Config.PseudoGTIDMatchMismatchLookahead)
+	config.Config.PseudoGTIDPattern = "_pseudo_gtid_"
+	config.Config.PseudoGTIDMatchMismatchLookahead = 1
+
+	entryText := "drop view if exists `meta`.`_pseudo_gtid_hint__translate`"
+	stubBinlogEvents("mysql-bin.000002", []stubEvent{
+		{120, 220, "Query", entryText},
+		{220, 320, "Query", "insert into t values (1)"},
+		{320, 420, "Query", "insert into t values (2)"},
+		{420, 520, "Query", "insert into t values (3)"},
+	})
+	instanceStub := inst.QueryBinlogEvents
+	// other holds an extra event, which the matcher's lookahead skips
+	stubBinlogEvents("mysql-bin.000007", []stubEvent{
+		{1000, 1100, "Query", entryText},
+		{1100, 1200, "Query", "insert into t values (1)"},
+		{1200, 1300, "Query", "insert into heartbeat values (now())"},
+		{1300, 1400, "Query", "insert into t values (2)"},
+		{1400, 1500, "Query", "insert into t values (3)"},
+	})
+	otherStub := inst.QueryBinlogEvents
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		if instanceKey.Hostname == "translate1.db" {
+			return instanceStub(instanceKey, query, onRow)
+		}
+		return otherStub(instanceKey, query, onRow)
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "translate1.db", Port: 3306}
+	instance.SetBinaryLogs([]string{"mysql-bin.000002"})
+	other := inst.NewInstance()
+	other.Key = inst.InstanceKey{Hostname: "translate0.db", Port: 3306}
+	other.SetBinaryLogs([]string{"mysql-bin.000007"})
+
+	coordinates, err := inst.TranslateBinlogCoordinates(instance, inst.BinlogCoordinates{LogFile: "mysql-bin.000002", LogPos: 420, Type: inst.BinaryLog}, other)
+	c.Assert(err, IsNil)
+	c.Assert(*coordinates, Equals, inst.BinlogCoordinates{LogFile: "mysql-bin.000007", LogPos: 1400, Type: inst.BinaryLog})
+}
+
+func (s *TestSuite) TestGetLastPseudoGTIDEntryInRelayLogsSince(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+	}(inst.QueryBinlogEvents)
+
+	logFileRegexp := regexp.MustCompile(`events in '([^']+)'`)
+	searchedRelayLogs := []string{}
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		searchedRelayLogs = append(searchedRelayLogs, logFileRegexp.FindStringSubmatch(query)[1])
+		return nil
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "floor0.db", Port: 3306}
+	instance.MasterKey = inst.InstanceKey{Hostname: "floor1.db", Port: 3306}
+	instance.ReadBinlogCoordinates = inst.BinlogCoordinates{LogFile: "mysql-bin.000042", LogPos: 1000}
+	instance.ExecBinlogCoordinates = instance.ReadBinlogCoordinates
+
+	// The floor is compared numerically: it is reached though its suffix is shorter
+	_, _, err := inst.GetLastPseudoGTIDEntryInRelayLogsSince(instance, inst.BinlogCoordinates{LogFile: "mysql-relay.1000000", LogPos: 300, Type: inst.RelayLog}, "mysql-relay.999999")
+	c.Assert(err, NotNil)
+	c.Assert(searchedRelayLogs, DeepEquals, []string{"mysql-relay.1000000", "mysql-relay.0999999"})
+}
+
+func (s *TestSuite) TestComputeBinlogLagBytes(c *C) {
+	binaryLogFiles := []inst.BinaryLogFile{
+		{LogFile: "mysql-bin.000010", Size: 1000},
+		{LogFile: "mysql-bin.000011", Size: 2000},
+		{LogFile: "mysql-bin.000012", Size: 500},
+	}
+	masterCoordinates := inst.BinlogCoordinates{LogFile: "mysql-bin.000012", LogPos: 300}
+
+	lagBytes, err := inst.ComputeBinlogLagBytes(masterCoordinates, inst.BinlogCoordinates{LogFile: "mysql-bin.000012", LogPos: 100}, binaryLogFiles)
+	c.Assert(err, IsNil)
+	c.Assert(lagBytes, Equals, int64(200))
+
+	lagBytes, err = inst.ComputeBinlogLagBytes(masterCoordinates, inst.BinlogCoordinates{LogFile: "mysql-bin.000010", LogPos: 400}, binaryLogFiles)
+	c.Assert(err, IsNil)
+	c.Assert(lagBytes, Equals, int64(600+2000+300))
+
+	_, err = inst.ComputeBinlogLagBytes(masterCoordinates, inst.BinlogCoordinates{LogFile: "mysql-bin.000009", LogPos: 400}, binaryLogFiles)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestMatchBelowByCoordinatesValidation(c *C) {
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "host1", Port: 3306}
+	other := inst.NewInstance()
+	other.Key = inst.InstanceKey{Hostname: "host2", Port: 3306}
+	coordinates := inst.BinlogCoordinates{LogFile: "mysql-bin.000010", LogPos: 120, Type: inst.BinaryLog}
+	noCoordinates := inst.BinlogCoordinates{}
+
+	_, err := inst.MatchBelowByCoordinates(instance, coordinates, noCoordinates, instance, coordinates)
+	c.Assert(err, Equals, inst.ErrSameInstance)
+
+	_, err = inst.MatchBelowByCoordinates(instance, noCoordinates, noCoordinates, other, coordinates)
+	c.Assert(err, NotNil)
+
+	_, err = inst.MatchBelowByCoordinates(instance, coordinates, noCoordinates, other, noCoordinates)
+	c.Assert(err, NotNil)
+
+	relayLogCoordinates := inst.BinlogCoordinates{LogFile: "mysql-relay.000003", LogPos: 400, Type: inst.RelayLog}
+	_, err = inst.MatchBelowByCoordinates(instance, relayLogCoordinates, noCoordinates, other, coordinates)
+	c.Assert(err, NotNil)
+
+	// A replicating instance makes for a moving target
+	instance.MasterKey = other.Key
+	instance.ReadBinlogCoordinates = inst.BinlogCoordinates{LogFile: "mysql-bin.000020", LogPos: 500}
+	instance.Slave_SQL_Running = true
+	_, err = inst.MatchBelowByCoordinates(instance, relayLogCoordinates, relayLogCoordinates, other, coordinates)
+	c.Assert(err, ErrorMatches, "Replication must be stopped on .*")
+}
+
+func (s *TestSuite) TestMatchBelowOnlineValidation(c *C) {
+	instanceKey := inst.InstanceKey{Hostname: "host1", Port: 3306}
+
+	_, _, err := inst.MatchBelowOnline(&instanceKey, &instanceKey, false, false)
+	c.Assert(err, ErrorMatches, "MatchBelowOnline: attempt to match an instance below itself .*")
+
+	_, _, err = inst.MatchBelowOnline(&instanceKey, nil, false, false)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestGetNextBinlogCoordinatesToMatchFromOnlineEntry(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, pattern string) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.PseudoGTIDPattern = pattern
+	}(inst.QueryBinlogEvents, config.Config.PseudoGTIDPattern)
+	config.Config.PseudoGTIDPattern = "_pseudo_gtid_"
+
+	entryText := "drop view if exists `meta`.`_pseudo_gtid_hint__online`"
+	stubBinlogEvents("mysql-relay.000003", []stubEvent{
+		{100, 200, "Query", "insert into t values (0)"},
+		{200, 300, "Query", entryText},
+		{300, 400, "Query", "insert into t values (1)"},
+		{400, 500, "Query", "insert into t values (2)"},
+	})
+	instanceStub := inst.QueryBinlogEvents
+	stubBinlogEvents("mysql-bin.000007", []stubEvent{
+		{1000, 1100, "Query", entryText},
+		{1100, 1200, "Query", "insert into t values (1)"},
+		{1200, 1300, "Query", "insert into t values (2)"},
+		{1300, 1400, "Query", "insert into t values (3)"},
+	})
+	otherStub := inst.QueryBinlogEvents
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		if instanceKey.Hostname == "online0.db" {
+			return instanceStub(instanceKey, query, onRow)
+		}
+		return otherStub(instanceKey, query, onRow)
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "online0.db", Port: 3306}
+	instance.MasterKey = inst.InstanceKey{Hostname: "master.db", Port: 3306}
+	instance.ReadBinlogCoordinates = inst.BinlogCoordinates{LogFile: "mysql-bin.000020", LogPos: 500}
+	instance.ExecBinlogCoordinates = instance.ReadBinlogCoordinates
+	other := inst.NewInstance()
+	other.Key = inst.InstanceKey{Hostname: "online1.db", Port: 3306}
+	other.SetBinaryLogs([]string{"mysql-bin.000007"})
+	recordedInstanceRelayLogCoordinates := inst.BinlogCoordinates{LogFile: "mysql-relay.000003", LogPos: 500, Type: inst.RelayLog}
+	otherCoordinates := inst.BinlogCoordinates{LogFile: "mysql-bin.000007", LogPos: 1000, Type: inst.BinaryLog}
+
+	// The entry found online is matched as is
+	instanceCoordinates := inst.BinlogCoordinates{LogFile: "mysql-relay.000003", LogPos: 200, Type: inst.RelayLog}
+	coordinates, err := inst.GetNextBinlogCoordinatesToMatchFromOnlineEntry(instance, &instanceCoordinates, recordedInstanceRelayLogCoordinates, other, &otherCoordinates)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogPos, Equals, int64(1300))
+
+	// The entry found online no longer matches (here: its coordinates are off); it is looked up again
+	instanceCoordinates = inst.BinlogCoordinates{LogFile: "mysql-relay.000003", LogPos: 100, Type: inst.RelayLog}
+	coordinates, err = inst.GetNextBinlogCoordinatesToMatchFromOnlineEntry(instance, &instanceCoordinates, recordedInstanceRelayLogCoordinates, other, &otherCoordinates)
+	c.Assert(err, IsNil)
+	c.Assert(coordinates.LogFile, Equals, "mysql-bin.000007")
+	c.Assert(coordinates.LogPos, Equals, int64(1300))
+}
+
+func (s *TestSuite) TestIsInfoTruncated(c *C) {
+	event := inst.BinlogEvent{EventType: "Query", Info: "insert into t values (1)"}
+	c.Assert(event.IsInfoTruncated(), Equals, false)
+	event.Info = "insert into t values (1), (2), ..."
+	c.Assert(event.IsInfoTruncated(), Equals, true)
+
+	event.Info = "insert into t values (1)"
+	config.Config.BinlogEventsInfoTruncationLength = 16
+	defer func() { config.Config.BinlogEventsInfoTruncationLength = 0 }()
+	c.Assert(event.IsInfoTruncated(), Equals, true)
+}
+
+func (s *TestSuite) TestTruncatedEventInfoFallback(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error, mysqlbinlogCommand string) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+		config.Config.MysqlbinlogCommand = mysqlbinlogCommand
+		config.Config.BinlogEventsTruncatedInfoFallback = false
+	}(inst.QueryBinlogEvents, config.Config.MysqlbinlogCommand)
+
+	command, cleanup := fakeMysqlbinlogCommand(c, mysqlbinlogStartPositionOutput)
+	defer cleanup()
+	// The fake mysqlbinlog leaves a marker, by which to tell whether it ran while the SHOW BINLOG EVENTS result
+	// set was still open
+	marker := filepath.Join(filepath.Dir(command), "mysqlbinlog.ran")
+	script, err := ioutil.ReadFile(command)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(command, []byte(fmt.Sprintf("%stouch %s\n", script, marker)), 0755), IsNil)
+	config.Config.MysqlbinlogCommand = command
+	stubBinlogEvents("mysql-bin.000017", []stubEvent{
+		{199, 311, "Query", "use `test`; drop view if exists `meta`.`_pseudo_gt..."},
+		{311, 342, "Xid", "COMMIT /* xid=28 */"},
+	})
+	stub := inst.QueryBinlogEvents
+	ranWhileQuerying := false
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		return stub(instanceKey, query, func(m sqlutils.RowMap) error {
+			err := onRow(m)
+			if _, statErr := os.Stat(marker); statErr == nil {
+				ranWhileQuerying = true
+			}
+			return err
+		})
+	}
+	instanceKey := &inst.InstanceKey{Hostname: "truncated.db", Port: 3306}
+	coordinates := inst.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 199, Type: inst.BinaryLog}
+
+	events, err := inst.PeekBinlogEvents(instanceKey, coordinates, 2)
+	c.Assert(err, IsNil)
+	c.Assert(events[0].Info, Equals, "use `test`; drop view if exists `meta`.`_pseudo_gt...")
+
+	config.Config.BinlogEventsTruncatedInfoFallback = true
+	events, err = inst.PeekBinlogEvents(instanceKey, coordinates, 2)
+	c.Assert(err, IsNil)
+	c.Assert(len(events), Equals, 2)
+	c.Assert(events[0].Info, Equals, "use `test`; drop view if exists `meta`.`_pseudo_gtid_hint__123`")
+	c.Assert(events[1].Coordinates.LogPos, Equals, int64(311))
+	_, err = os.Stat(marker)
+	c.Assert(err, IsNil)
+	c.Assert(ranWhileQuerying, Equals, false)
+}
+
+func (s *TestSuite) TestGetLastPseudoGTIDEntryInRelayLogsNotSlave(c *C) {
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "host1", Port: 3306}
+	_, _, err := inst.GetLastPseudoGTIDEntryInRelayLogs(instance, inst.BinlogCoordinates{Type: inst.RelayLog})
+	c.Assert(err, Equals, inst.ErrNoRelayLogs)
+}
+
+func (s *TestSuite) TestSearchPseudoGTIDEntryInInstanceSince(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+	}(inst.QueryBinlogEvents)
+
+	entryText := "drop view if exists `meta`.`_pseudo_gtid_hint__since`"
+	stubBinlogEvents("mysql-bin.000003", []stubEvent{
+		{120, 220, "Query", "insert into t values (1)"},
+		{220, 320, "Query", entryText},
+	})
+	stubbedQueryBinlogEvents := inst.QueryBinlogEvents
+	logFileRegexp := regexp.MustCompile(`events in '([^']+)' FROM ([0-9]+)`)
+	queries := []string{}
+	inst.QueryBinlogEvents = func(instanceKey *inst.InstanceKey, query string, onRow func(sqlutils.RowMap) error) error {
+		submatch := logFileRegexp.FindStringSubmatch(query)
+		queries = append(queries, submatch[1]+":"+submatch[2])
+		if submatch[1] != "mysql-bin.000003" {
+			return nil
+		}
+		return stubbedQueryBinlogEvents(instanceKey, query, onRow)
+	}
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "since0.db", Port: 3306}
+	instance.SetBinaryLogs([]string{"mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003"})
+
+	coordinates, err := inst.SearchPseudoGTIDEntryInInstanceSince(instance, entryText, inst.BinlogCoordinates{LogFile: "mysql-bin.000002", LogPos: 500, Type: inst.BinaryLog})
+	c.Assert(err, IsNil)
+	c.Assert(*coordinates, Equals, inst.BinlogCoordinates{LogFile: "mysql-bin.000003", LogPos: 220, Type: inst.BinaryLog})
+	// Older binary logs, and the part of the first one preceding given coordinates, are not scanned
+	c.Assert(queries, DeepEquals, []string{"mysql-bin.000002:500", "mysql-bin.000003:0"})
+
+	_, err = inst.SearchPseudoGTIDEntryInInstanceSince(instance, entryText, inst.BinlogCoordinates{LogFile: "mysql-bin.000003", LogPos: 320, Type: inst.BinaryLog})
+	c.Assert(err, Equals, inst.ErrAnchorNotFoundOnTarget)
+}
+
+func (s *TestSuite) TestRelayToMasterCoordinate(c *C) {
+	defer func(queryBinlogEvents func(*inst.InstanceKey, string, func(sqlutils.RowMap) error) error) {
+		inst.QueryBinlogEvents = queryBinlogEvents
+	}(inst.QueryBinlogEvents)
+
+	// Relay log events: positions are the relay log's, End_log_pos the master's
+	stubBinlogEvents("mysql-relay.000007", []stubEvent{
+		{4, 120, "Format_desc", "Server ver: 5.6.23-log, Binlog ver: 4"},
+		{120, 0, "Rotate", "mysql-bin.000042;pos=4"},
+		{167, 0, "Format_desc", "Server ver: 5.6.23-log, Binlog ver: 4"},
+		{283, 1320, "Query", "BEGIN"},
+		{350, 1410, "Query", "insert into t values (1)"},
+		{440, 1437, "Xid", "COMMIT /* xid=17 */"},
+	})
+	instance := inst.NewInstance()
+	instance.Key = inst.InstanceKey{Hostname: "relay.db", Port: 3306}
+
+	masterCoordinates, err := inst.RelayToMasterCoordinate(instance, inst.BinlogCoordinates{LogFile: "mysql-relay.000007", LogPos: 350, Type: inst.RelayLog})
+	c.Assert(err, IsNil)
+	c.Assert(*masterCoordinates, Equals, inst.BinlogCoordinates{LogFile: "mysql-bin.000042", LogPos: 1410, Type: inst.BinaryLog})
+
+	masterCoordinates, err = inst.RelayToMasterCoordinate(instance, inst.BinlogCoordinates{LogFile: "mysql-relay.000007", LogPos: 120, Type: inst.RelayLog})
+	c.Assert(err, IsNil)
+	c.Assert(*masterCoordinates, Equals, inst.BinlogCoordinates{LogFile: "mysql-bin.000042", LogPos: 4, Type: inst.BinaryLog})
+
+	_, err = inst.RelayToMasterCoordinate(instance, inst.BinlogCoordinates{LogFile: "mysql-relay.000007", LogPos: 300, Type: inst.RelayLog})
+	c.Assert(err, NotNil)
+	_, err = inst.RelayToMasterCoordinate(instance, inst.BinlogCoordinates{LogFile: "mysql-relay.000007", LogPos: 4, Type: inst.RelayLog})
+	c.Assert(err, NotNil)
+}
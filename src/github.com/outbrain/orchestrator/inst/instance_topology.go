@@ -416,11 +416,189 @@ func FindLastPseudoGTIDEntry(instance *Instance, recordedInstanceRelayLogCoordin
 		// Unable to find pseudo GTID in binary logs.
 		// Then MAYBE we are lucky enough (chances are we are, if this slave did not crash) that we can
 		// extract the Pseudo GTID entry from the last (current) relay log file.
+		binlogErr := err
 		instancePseudoGtidCoordinates, instancePseudoGtidText, err = GetLastPseudoGTIDEntryInRelayLogs(instance, recordedInstanceRelayLogCoordinates)
+		if err == ErrNoRelayLogs && binlogErr != nil {
+			// Not a slave; the binary logs search is what failed
+			err = binlogErr
+		}
 	}
 	return instancePseudoGtidCoordinates, instancePseudoGtidText, err
 }
 
+// FindLastPseudoGTIDEntryForMatch is FindLastPseudoGTIDEntry, tuned for matching instance below otherInstance:
+// when the two differ in binlog_format, instance's own binary logs hold events re-logged in a different format than
+// otherInstance's, and are unlikely to match. Its relay logs, which reflect what instance actually applied, are then
+// preferred, falling back to the binary logs should that fail.
+func FindLastPseudoGTIDEntryForMatch(instance *Instance, otherInstance *Instance, recordedInstanceRelayLogCoordinates BinlogCoordinates) (*BinlogCoordinates, string, error) {
+	if instance.Binlog_format != otherInstance.Binlog_format && recordedInstanceRelayLogCoordinates.LogFile != "" {
+		log.Debugf("%+v has binlog_format %s while %+v has %s; searching relay logs first", instance.Key, instance.Binlog_format, otherInstance.Key, otherInstance.Binlog_format)
+		if coordinates, entryText, err := GetLastPseudoGTIDEntryInRelayLogs(instance, recordedInstanceRelayLogCoordinates); err == nil {
+			return coordinates, entryText, nil
+		}
+	}
+	return FindLastPseudoGTIDEntry(instance, recordedInstanceRelayLogCoordinates)
+}
+
+// FindCommonPseudoGTIDEntry looks up the given Pseudo-GTID entry of instance in otherInstance's binary logs.
+// Should the entry not be found (e.g. it is not, or no longer, on otherInstance), it falls back to successively
+// older Pseudo-GTID entries of instance, up to PseudoGTIDMatchMaxFallbackAnchors of them. Any other error is final.
+// Should the entry turn out to predate otherInstance's binary logs (see findCommonPseudoGTIDEntryAfterPurge), the
+// oldest entry of otherInstance is used instead, if instance has it.
+// It returns the coordinates of the common entry on both instances, along with the entry text.
+// Should no common entry be found, ErrAnchorNotFoundOnTarget is returned.
+func FindCommonPseudoGTIDEntry(instance *Instance, instancePseudoGtidCoordinates *BinlogCoordinates, instancePseudoGtidText string, otherInstance *Instance) (*BinlogCoordinates, *BinlogCoordinates, string, error) {
+	otherInstancePseudoGtidCoordinates, err := SearchPseudoGTIDEntryInInstance(otherInstance, instancePseudoGtidText)
+	for fallback := uint(1); err == ErrAnchorNotFoundOnTarget && fallback <= config.Config.PseudoGTIDMatchMaxFallbackAnchors; fallback++ {
+		log.Debugf("Cannot find pseudo gtid entry of %+v at %+v on %+v; falling back to previous entry (%d/%d)", instance.Key, *instancePseudoGtidCoordinates, otherInstance.Key, fallback, config.Config.PseudoGTIDMatchMaxFallbackAnchors)
+		instancePseudoGtidCoordinates, instancePseudoGtidText, err = GetPreviousPseudoGTIDEntry(instance, *instancePseudoGtidCoordinates)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		otherInstancePseudoGtidCoordinates, err = SearchPseudoGTIDEntryInInstance(otherInstance, instancePseudoGtidText)
+	}
+	if err == ErrAnchorNotFoundOnTarget && instancePseudoGtidCoordinates.Type == BinaryLog {
+		return findCommonPseudoGTIDEntryAfterPurge(instance, instancePseudoGtidCoordinates, otherInstance)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return instancePseudoGtidCoordinates, otherInstancePseudoGtidCoordinates, instancePseudoGtidText, nil
+}
+
+// findCommonPseudoGTIDEntryAfterPurge handles an anchor of instance which is not found on otherInstance as it was
+// purged there: otherInstance's binary logs begin after the anchor. This is detected by locating otherInstance's
+// oldest Pseudo-GTID entry in instance's binary logs, positioned after the anchor. That entry then makes for a
+// later common anchor. An entry found before the anchor, or not at all, indicates divergence rather than a purge,
+// and ErrAnchorNotFoundOnTarget stands.
+// Only binary log anchors are supported, since entries are searched for in instance's binary logs.
+func findCommonPseudoGTIDEntryAfterPurge(instance *Instance, instancePseudoGtidCoordinates *BinlogCoordinates, otherInstance *Instance) (*BinlogCoordinates, *BinlogCoordinates, string, error) {
+	otherFirstPseudoGtidCoordinates, otherFirstPseudoGtidText, err := GetFirstPseudoGTIDEntryInInstance(otherInstance)
+	if err != nil {
+		return nil, nil, "", ErrAnchorNotFoundOnTarget
+	}
+	instanceCoordinates, err := SearchPseudoGTIDEntryInInstance(instance, otherFirstPseudoGtidText)
+	if err != nil {
+		return nil, nil, "", ErrAnchorNotFoundOnTarget
+	}
+	if !instancePseudoGtidCoordinates.SmallerThan(instanceCoordinates) {
+		log.Debugf("Oldest pseudo gtid entry of %+v is found on %+v at %+v, not after anchor %+v; not a purge", otherInstance.Key, instance.Key, *instanceCoordinates, *instancePseudoGtidCoordinates)
+		return nil, nil, "", ErrAnchorNotFoundOnTarget
+	}
+	log.Warningf("Pseudo gtid entry of %+v at %+v is purged on %+v; retrying from oldest entry of %+v, found on %+v at %+v", instance.Key, *instancePseudoGtidCoordinates, otherInstance.Key, otherInstance.Key, instance.Key, *instanceCoordinates)
+	return instanceCoordinates, otherFirstPseudoGtidCoordinates, otherFirstPseudoGtidText, nil
+}
+
+// CanPotentiallyMatch is a cheap pre-check for MatchBelow: it confirms otherInstance contains instance's latest
+// Pseudo-GTID entry, without the full event-by-event comparison. It returns the coordinates of that shared entry
+// on otherInstance. A positive answer does not guarantee a successful match (e.g. instance may turn out to be
+// more advanced than otherInstance).
+func CanPotentiallyMatch(instance, otherInstance *Instance) (bool, *BinlogCoordinates, error) {
+	_, instancePseudoGtidText, err := FindLastPseudoGTIDEntry(instance, instance.RelaylogCoordinates)
+	if err != nil {
+		return false, nil, err
+	}
+	// Shortcut: both share the very same latest entry
+	if otherPseudoGtidCoordinates, otherPseudoGtidText, err := GetLastPseudoGTIDEntryInInstance(otherInstance); err == nil && EventInfoEquals(instancePseudoGtidText, otherPseudoGtidText) {
+		return true, otherPseudoGtidCoordinates, nil
+	}
+	otherPseudoGtidCoordinates, err := SearchPseudoGTIDEntryInInstance(otherInstance, instancePseudoGtidText)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, otherPseudoGtidCoordinates, nil
+}
+
+// MatchBelowByAnchor computes the coordinates on otherInstance at which instance would resume replication, were
+// it to replicate from otherInstance. It finds instance's latest Pseudo-GTID entry (as of instance's recorded relay
+// log coordinates), looks it up in otherInstance, and matches the events following that shared anchor on both.
+// It does not modify either instance; callers are expected to have stopped replication on instance.
+func MatchBelowByAnchor(instance, otherInstance *Instance) (*BinlogCoordinates, error) {
+	recordedInstanceRelayLogCoordinates := instance.RelaylogCoordinates
+	instancePseudoGtidCoordinates, instancePseudoGtidText, err := FindLastPseudoGTIDEntryForMatch(instance, otherInstance, recordedInstanceRelayLogCoordinates)
+	if err != nil {
+		return nil, err
+	}
+	instancePseudoGtidCoordinates, otherInstancePseudoGtidCoordinates, _, err := FindCommonPseudoGTIDEntry(instance, instancePseudoGtidCoordinates, instancePseudoGtidText, otherInstance)
+	if err != nil {
+		return nil, err
+	}
+	return GetNextBinlogCoordinatesToMatch(instance, *instancePseudoGtidCoordinates,
+		recordedInstanceRelayLogCoordinates, otherInstance, *otherInstancePseudoGtidCoordinates, nil)
+}
+
+// MatchBelowByAnchorChain is MatchBelowByAnchor across a replication chain: it computes the coordinates at which
+// instance would resume replication from the last of the chain, where each member of the chain replicates from
+// the next (e.g. instance's master followed by its grandparent). Coordinates found on each member are translated
+// onto the next via TranslateBinlogCoordinates.
+func MatchBelowByAnchorChain(instance *Instance, chain ...*Instance) (*BinlogCoordinates, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("MatchBelowByAnchorChain: empty chain")
+	}
+	coordinates, err := MatchBelowByAnchor(instance, chain[0])
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(chain); i++ {
+		coordinates, err = TranslateBinlogCoordinates(chain[i-1], *coordinates, chain[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return coordinates, nil
+}
+
+// validateBinlogCoordinatesToMatch validates the arguments of an explicit coordinates match. See MatchBelowByCoordinates.
+func validateBinlogCoordinatesToMatch(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates) error {
+	if instance == nil || other == nil {
+		return errors.New("Both instance and other must be given")
+	}
+	if instance.Key.Equals(&other.Key) {
+		return ErrSameInstance
+	}
+	if instance.Slave_SQL_Running || instance.Slave_IO_Running {
+		return errors.New(fmt.Sprintf("Replication must be stopped on %+v", instance.Key))
+	}
+	if instanceCoordinates.LogFile == "" || instanceCoordinates.LogPos <= 0 {
+		return errors.New(fmt.Sprintf("Invalid coordinates on %+v: %+v", instance.Key, instanceCoordinates))
+	}
+	if otherCoordinates.LogFile == "" || otherCoordinates.LogPos <= 0 {
+		return errors.New(fmt.Sprintf("Invalid coordinates on %+v: %+v", other.Key, otherCoordinates))
+	}
+	if otherCoordinates.Type == RelayLog && !other.IsSlave() {
+		return errors.New(fmt.Sprintf("Relay log coordinates given on %+v, which is not a slave", other.Key))
+	}
+	if instanceCoordinates.Type == RelayLog {
+		if !instance.IsSlave() {
+			return errors.New(fmt.Sprintf("Relay log coordinates given on %+v, which is not a slave", instance.Key))
+		}
+		if recordedInstanceRelayLogCoordinates.LogFile == "" || recordedInstanceRelayLogCoordinates.LogPos <= 0 {
+			return errors.New(fmt.Sprintf("Invalid recorded relay log coordinates on %+v: %+v", instance.Key, recordedInstanceRelayLogCoordinates))
+		}
+		if recordedInstanceRelayLogCoordinates.Type != RelayLog {
+			return errors.New(fmt.Sprintf("Recorded relay log coordinates on %+v are not of relay log type: %+v", instance.Key, recordedInstanceRelayLogCoordinates))
+		}
+		if recordedInstanceRelayLogCoordinates.SmallerThan(&instanceCoordinates) {
+			return errors.New(fmt.Sprintf("Coordinates on %+v (%+v) are past recorded relay log coordinates (%+v)", instance.Key, instanceCoordinates, recordedInstanceRelayLogCoordinates))
+		}
+	}
+	return nil
+}
+
+// MatchBelowByCoordinates computes the coordinates on other at which instance would resume replication, were it to
+// replicate from other, given explicit twin coordinates: instanceCoordinates on instance and otherCoordinates on
+// other are expected to point at the same Pseudo-GTID entry, as found out-of-band. Unlike GetNextBinlogCoordinatesToMatch,
+// it first validates its arguments, making it suitable for exposing via API. Replication on instance must be
+// stopped, as per its given status; otherwise its coordinates would be a moving target.
+func MatchBelowByCoordinates(instance *Instance, instanceCoordinates BinlogCoordinates, recordedInstanceRelayLogCoordinates BinlogCoordinates,
+	other *Instance, otherCoordinates BinlogCoordinates) (*BinlogCoordinates, error) {
+	if err := validateBinlogCoordinatesToMatch(instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, other, otherCoordinates); err != nil {
+		return nil, log.Errore(err)
+	}
+	return GetNextBinlogCoordinatesToMatch(instance, instanceCoordinates, recordedInstanceRelayLogCoordinates, other, otherCoordinates, nil)
+}
+
 // MatchBelow will attempt moving instance indicated by instanceKey below its the one indicated by otherKey.
 // The refactoring is based on matching binlog entries, not on "classic" positions comparisons.
 // The "other instance" could be the sibling of the moving instance any of its ancestors. It may actuall be
@@ -449,11 +627,7 @@ func MatchBelow(instanceKey, otherKey *InstanceKey, requireInstanceMaintenance b
 	}
 	log.Infof("Will match %+v below %+v", *instanceKey, *otherKey)
 
-	var instancePseudoGtidText string
-	var instancePseudoGtidCoordinates *BinlogCoordinates
-	var otherInstancePseudoGtidCoordinates *BinlogCoordinates
 	var nextBinlogCoordinatesToMatch *BinlogCoordinates
-	var recordedInstanceRelayLogCoordinates BinlogCoordinates
 
 	if requireInstanceMaintenance {
 		if maintenanceToken, merr := BeginMaintenance(instanceKey, "orchestrator", fmt.Sprintf("match below %+v", *otherKey)); merr != nil {
@@ -477,22 +651,13 @@ func MatchBelow(instanceKey, otherKey *InstanceKey, requireInstanceMaintenance b
 	if err != nil {
 		goto Cleanup
 	}
-	// We record the relay log coordinates just after the instance stopped since the coordinates can change upon
-	// a FLUSH LOGS/FLUSH RELAY LOGS (or a START SLAVE, though that's an altogether different problem) etc.
+	// The instance, as read just after it stopped, records the relay log coordinates we match against, since the
+	// coordinates can change upon a FLUSH LOGS/FLUSH RELAY LOGS (or a START SLAVE, though that's an altogether
+	// different problem) etc.
 	// We want to be on the safe side; we don't utterly trust that we are the only ones playing with the instance.
-	recordedInstanceRelayLogCoordinates = instance.RelaylogCoordinates
-	instancePseudoGtidCoordinates, instancePseudoGtidText, err = FindLastPseudoGTIDEntry(instance, recordedInstanceRelayLogCoordinates)
-
-	if err != nil {
-		goto Cleanup
-	}
-	otherInstancePseudoGtidCoordinates, err = SearchPseudoGTIDEntryInInstance(otherInstance, instancePseudoGtidText)
-	if err != nil {
-		goto Cleanup
-	}
-
-	// We've found a match: the latest Pseudo GTID position within instance and its identical twin in otherInstance
-	// We now iterate the events in both, up to the completion of events in instance (recall that we looked for
+	//
+	// We look for the latest Pseudo GTID position within instance and its identical twin in otherInstance.
+	// We then iterate the events in both, up to the completion of events in instance (recall that we looked for
 	// the last entry in instance, hence, assuming pseudo GTID entries are frequent, the amount of entries to read
 	// from instance is not long)
 	// The result of the iteration will be either:
@@ -504,8 +669,7 @@ func MatchBelow(instanceKey, otherKey *InstanceKey, requireInstanceMaintenance b
 	// - good result: the first position within otherInstance where instance has not replicated yet. It is easy to point
 	//   instance into otherInstance.
 
-	nextBinlogCoordinatesToMatch, err = GetNextBinlogCoordinatesToMatch(instance, *instancePseudoGtidCoordinates,
-		recordedInstanceRelayLogCoordinates, otherInstance, *otherInstancePseudoGtidCoordinates)
+	nextBinlogCoordinatesToMatch, err = MatchBelowByAnchor(instance, otherInstance)
 	if err != nil {
 		goto Cleanup
 	}
@@ -528,6 +692,134 @@ Cleanup:
 	return instance, nextBinlogCoordinatesToMatch, err
 }
 
+// MatchBelowOnline is similar to MatchBelow, but keeps instance replicating while doing the heavyweight work.
+// It snapshots the instance's relay log position while replicating, and against that frozen point looks up the last
+// Pseudo-GTID entry in instance and its twin in otherInstance. Only then is replication stopped, at which point
+// the (short) sequential match from the Pseudo-GTID entry onwards is computed and CHANGE MASTER is issued.
+// Should the Pseudo-GTID entry found online no longer be readable once stopped, the lookup is repeated while
+// stopped (see GetNextBinlogCoordinatesToMatchFromOnlineEntry).
+func MatchBelowOnline(instanceKey, otherKey *InstanceKey, requireInstanceMaintenance bool, requireOtherMaintenance bool) (*Instance, *BinlogCoordinates, error) {
+	if instanceKey == nil || otherKey == nil {
+		return nil, nil, errors.New("MatchBelowOnline: instance and other instance must be given")
+	}
+	if instanceKey.Equals(otherKey) {
+		return nil, nil, errors.New(fmt.Sprintf("MatchBelowOnline: attempt to match an instance below itself %+v", *instanceKey))
+	}
+	instance, err := ReadTopologyInstance(instanceKey)
+	if err != nil {
+		return instance, nil, err
+	}
+	otherInstance, err := ReadTopologyInstance(otherKey)
+	if err != nil {
+		return instance, nil, err
+	}
+
+	rinstance, _, err := ReadInstance(&instance.Key)
+	if err != nil {
+		return instance, nil, log.Errore(err)
+	}
+	if rinstance == nil {
+		return instance, nil, errors.New(fmt.Sprintf("MatchBelowOnline: cannot read %+v from backend", *instanceKey))
+	}
+	if canMove, merr := rinstance.CanMoveViaMatch(); !canMove {
+		return instance, nil, merr
+	}
+
+	if canReplicate, err := instance.CanReplicateFrom(otherInstance); !canReplicate {
+		return instance, nil, err
+	}
+	log.Infof("Will match %+v below %+v online", *instanceKey, *otherKey)
+
+	var instancePseudoGtidText string
+	var instancePseudoGtidCoordinates *BinlogCoordinates
+	var otherInstancePseudoGtidCoordinates *BinlogCoordinates
+	var nextBinlogCoordinatesToMatch *BinlogCoordinates
+	var snapshotInstanceRelayLogCoordinates BinlogCoordinates
+	var recordedInstanceRelayLogCoordinates BinlogCoordinates
+
+	if requireInstanceMaintenance {
+		if maintenanceToken, merr := BeginMaintenance(instanceKey, "orchestrator", fmt.Sprintf("match below %+v online", *otherKey)); merr != nil {
+			return instance, nil, errors.New(fmt.Sprintf("Cannot begin maintenance on %+v", *instanceKey))
+		} else {
+			defer EndMaintenance(maintenanceToken)
+		}
+	}
+	if requireOtherMaintenance {
+		if maintenanceToken, merr := BeginMaintenance(otherKey, "orchestrator", fmt.Sprintf("%+v matches below this online", *instanceKey)); merr != nil {
+			return instance, nil, errors.New(fmt.Sprintf("Cannot begin maintenance on %+v", *otherKey))
+		} else {
+			defer EndMaintenance(maintenanceToken)
+		}
+	}
+
+	// Online phase: the instance keeps replicating. The relay log position is a moving target, so we freeze it
+	// and do not look beyond it.
+	snapshotInstanceRelayLogCoordinates = instance.RelaylogCoordinates
+	log.Debugf("Searching for pseudo gtid entry on %+v online, up to relay log snapshot %+v", *instanceKey, snapshotInstanceRelayLogCoordinates)
+	instancePseudoGtidCoordinates, instancePseudoGtidText, err = FindLastPseudoGTIDEntryForMatch(instance, otherInstance, snapshotInstanceRelayLogCoordinates)
+	if err != nil {
+		return instance, nil, log.Errore(err)
+	}
+	instancePseudoGtidCoordinates, otherInstancePseudoGtidCoordinates, instancePseudoGtidText, err = FindCommonPseudoGTIDEntry(instance, instancePseudoGtidCoordinates, instancePseudoGtidText, otherInstance)
+	if err != nil {
+		return instance, nil, log.Errore(err)
+	}
+
+	// Offline phase: as brief as we can make it
+	log.Debugf("Stopping slave on %+v", *instanceKey)
+	instance, err = StopSlave(instanceKey)
+	if err != nil {
+		goto Cleanup
+	}
+	recordedInstanceRelayLogCoordinates = instance.RelaylogCoordinates
+
+	nextBinlogCoordinatesToMatch, err = GetNextBinlogCoordinatesToMatchFromOnlineEntry(instance, instancePseudoGtidCoordinates,
+		recordedInstanceRelayLogCoordinates, otherInstance, otherInstancePseudoGtidCoordinates)
+	if err != nil {
+		goto Cleanup
+	}
+	log.Debugf("%+v will match below %+v at %+v", *instanceKey, *otherKey, *nextBinlogCoordinatesToMatch)
+
+	instance, err = ChangeMasterTo(instanceKey, otherKey, nextBinlogCoordinatesToMatch)
+	if err != nil {
+		goto Cleanup
+	}
+
+Cleanup:
+	instance, _ = StartSlave(instanceKey)
+	if err != nil {
+		return instance, nextBinlogCoordinatesToMatch, log.Errore(err)
+	}
+	// and we're done (pending deferred functions)
+	AuditOperation("match-below-online", instanceKey, fmt.Sprintf("matched %+v below %+v online", *instanceKey, *otherKey))
+
+	return instance, nextBinlogCoordinatesToMatch, err
+}
+
+// GetNextBinlogCoordinatesToMatchFromOnlineEntry is the offline phase of MatchBelowOnline: given the common
+// Pseudo-GTID entry of instance and otherInstance, as found while instance was replicating, it computes the
+// coordinates at which the now stopped instance is to match below otherInstance. Should the entry no longer be
+// readable (e.g. its relay log was purged in the meantime), the lookup is repeated while stopped, as with MatchBelow.
+func GetNextBinlogCoordinatesToMatchFromOnlineEntry(instance *Instance, instancePseudoGtidCoordinates *BinlogCoordinates,
+	recordedInstanceRelayLogCoordinates BinlogCoordinates, otherInstance *Instance, otherInstancePseudoGtidCoordinates *BinlogCoordinates) (*BinlogCoordinates, error) {
+	nextBinlogCoordinatesToMatch, err := GetNextBinlogCoordinatesToMatch(instance, *instancePseudoGtidCoordinates,
+		recordedInstanceRelayLogCoordinates, otherInstance, *otherInstancePseudoGtidCoordinates, nil)
+	if err == nil {
+		return nextBinlogCoordinatesToMatch, nil
+	}
+	log.Debugf("Cannot match %+v from online pseudo gtid entry at %+v; will look it up again: %+v", instance.Key, *instancePseudoGtidCoordinates, err)
+	instancePseudoGtidCoordinates, instancePseudoGtidText, err := FindLastPseudoGTIDEntryForMatch(instance, otherInstance, recordedInstanceRelayLogCoordinates)
+	if err != nil {
+		return nil, err
+	}
+	instancePseudoGtidCoordinates, otherInstancePseudoGtidCoordinates, _, err = FindCommonPseudoGTIDEntry(instance, instancePseudoGtidCoordinates, instancePseudoGtidText, otherInstance)
+	if err != nil {
+		return nil, err
+	}
+	return GetNextBinlogCoordinatesToMatch(instance, *instancePseudoGtidCoordinates,
+		recordedInstanceRelayLogCoordinates, otherInstance, *otherInstancePseudoGtidCoordinates, nil)
+}
+
 // MakeMaster will take an instance, make all its siblings its slaves (via pseudo-GTID) and make it master
 // (stop its replicaiton, make writeable).
 func MakeMaster(instanceKey *InstanceKey) (*Instance, error) {
@@ -125,6 +125,14 @@ func ContinuousDiscovery() {
 	go handleDiscoveryRequests(nil, nil)
 	tick := time.Tick(time.Duration(config.Config.DiscoveryPollSeconds) * time.Second)
 	forgetUnseenTick := time.Tick(time.Minute)
+	var verifyPseudoGTIDCacheTick <-chan time.Time
+	if config.Config.PseudoGTIDCacheVerifyMinutes > 0 {
+		verifyPseudoGTIDCacheTick = time.Tick(time.Duration(config.Config.PseudoGTIDCacheVerifyMinutes) * time.Minute)
+	}
+	var warmPseudoGTIDCacheTick <-chan time.Time
+	if config.Config.PseudoGTIDCacheWarmMinutes > 0 {
+		warmPseudoGTIDCacheTick = time.Tick(time.Duration(config.Config.PseudoGTIDCacheWarmMinutes) * time.Minute)
+	}
 	for {
 		select {
 		case <-tick:
@@ -143,6 +151,15 @@ func ContinuousDiscovery() {
 			inst.ForgetExpiredHostnameResolves()
 			inst.ReviewUnseenInstances()
 			inst.InjectUnseenMasters()
+		case <-verifyPseudoGTIDCacheTick:
+			// Binlog scans are heavyweight; only the active node runs these
+			if elected, _ := IsElected(); elected {
+				go inst.VerifyPseudoGTIDCache()
+			}
+		case <-warmPseudoGTIDCacheTick:
+			if elected, _ := IsElected(); elected {
+				go inst.WarmPseudoGTIDCache()
+			}
 		}
 	}
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/outbrain/golib/log"
 	"github.com/outbrain/orchestrator/app"
 	"github.com/outbrain/orchestrator/config"
+	"github.com/outbrain/orchestrator/inst"
 )
 
 // main is the application's entry point. It will either spawn a CLI or HTTP itnerfaces.
@@ -57,6 +58,10 @@ func main() {
 	} else {
 		config.Read("/etc/orchestrator.conf.json", "conf/orchestrator.conf.json", "orchestrator.conf.json")
 	}
+	inst.ValidatePseudoGTIDPattern()
+	if err := inst.ValidateBinlogEventsColumns(); err != nil {
+		log.Fatale(err)
+	}
 
 	switch {
 	case len(flag.Args()) == 0 || flag.Arg(0) == "cli":